@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultBackoffMax is the default backoff_max value (seconds).
+	DefaultBackoffMax = 300
+)
+
+// DomainBackoff struct has the consecutive lookup failures of the domain.
+type DomainBackoff struct {
+	ConsecutiveFailures uint64
+	RetryAt             time.Time
+}
+
+// fullJitter returns a random backoff between 0 and min(max, base * 2^(failures-1)).
+func fullJitter(base time.Duration, max time.Duration, failures uint64) time.Duration {
+	ceiling := base
+
+	for i := uint64(1); i < failures && ceiling < max; i++ {
+		ceiling *= 2
+	}
+
+	if ceiling > max {
+		ceiling = max
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// recordLookup resets the backoff of the domain (or the browsed service) if the lookup succeeded, and extends it if the lookup failed.
+// The caller must hold the mutex.
+// The answers cached (e.g. negative answers) are not failures of the resolvers and do not back off.
+func (dnsCli *DNSClient) recordLookup(backoffs map[string]*DomainBackoff, domain string, status *DomainStatus, cached bool) {
+	if cached || status.Reason == DomainReasonOK || status.Reason == DomainReasonUnavailable {
+		if backoff, ok := backoffs[domain]; ok {
+			log.Printf("%s lookup has recovered after %d consecutive failures", domain, backoff.ConsecutiveFailures)
+			delete(backoffs, domain)
+		}
+
+		return
+	}
+
+	if dnsCli.BackoffBase <= 0 || dnsCli.BackoffMax <= 0 {
+		return
+	}
+
+	backoff, ok := backoffs[domain]
+
+	if !ok {
+		backoff = &DomainBackoff{}
+		backoffs[domain] = backoff
+	}
+
+	backoff.ConsecutiveFailures++
+	wait := fullJitter(dnsCli.BackoffBase, dnsCli.BackoffMax, backoff.ConsecutiveFailures)
+	backoff.RetryAt = time.Now().Add(wait)
+	retryAt := backoff.RetryAt
+	status.ConsecutiveFailures = backoff.ConsecutiveFailures
+	status.RetryAt = &retryAt
+
+	if backoff.ConsecutiveFailures > 1 {
+		log.Printf("WARNING: %s lookup is backed off for %s after %d consecutive failures", domain, wait.Round(time.Millisecond), backoff.ConsecutiveFailures)
+	}
+}
+
+// backedOff returns whether the lookup of the domain (or the browsed service) is backed off.
+func (dnsCli *DNSClient) backedOff(backoffs map[string]*DomainBackoff, domain string) bool {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	backoff, ok := backoffs[domain]
+	return ok && time.Now().Before(backoff.RetryAt)
+}
+
+// Splay returns a random delay between 0 and splay to randomize the polling phase.
+func Splay(splay time.Duration) time.Duration {
+	if splay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(splay)))
+}
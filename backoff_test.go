@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func TestFullJitter(t *testing.T) {
+	assert := assert.New(t)
+
+	for i := 0; i < 100; i++ {
+		assert.True(fullJitter(time.Second, time.Minute, 1) <= time.Second)
+		assert.True(fullJitter(time.Second, time.Minute, 3) <= 4*time.Second)
+		assert.True(fullJitter(time.Second, time.Minute, 64) <= time.Minute)
+	}
+}
+
+func TestSplay(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(time.Duration(0), Splay(0))
+
+	for i := 0; i < 100; i++ {
+		assert.True(Splay(time.Second) < time.Second)
+	}
+}
+
+func TestDNSClientDigWithBackoff(t *testing.T) {
+	assert := assert.New(t)
+	var queries int32
+	var failing int32 = 1
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+
+		if atomic.LoadInt32(&failing) == 1 {
+			m := &dns.Msg{}
+			m.SetRcode(req, dns.RcodeServerFailure)
+			w.WriteMsg(m)
+			return
+		}
+
+		srvHandler(3)(w, req)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+		Interval:    3600,
+		BackoffMax:  3600,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	assert.Equal(0, len(dnsCli.Dig()["_mysql._tcp.example.com"]))
+	assert.Equal(int32(1), atomic.LoadInt32(&queries))
+	status := dnsCli.Statuses["_mysql._tcp.example.com"]
+	assert.Equal(uint64(1), status.ConsecutiveFailures)
+	assert.Equal(dnsCli.Backoffs["_mysql._tcp.example.com"].RetryAt, *status.RetryAt)
+
+	// The domain is not queried during the backoff
+	dnsCli.Backoffs["_mysql._tcp.example.com"].RetryAt = time.Now().Add(time.Hour)
+	assert.Equal(0, len(dnsCli.Dig()["_mysql._tcp.example.com"]))
+	assert.Equal(int32(1), atomic.LoadInt32(&queries))
+
+	// The backoff is reset by the successful lookup after it expires
+	dnsCli.Backoffs["_mysql._tcp.example.com"].RetryAt = time.Now().Add(-time.Second)
+	atomic.StoreInt32(&failing, 0)
+	assert.Equal(1, len(dnsCli.Dig()["_mysql._tcp.example.com"]))
+	assert.Equal(int32(2), atomic.LoadInt32(&queries))
+	assert.Equal(DomainStatus{Reason: DomainReasonOK, FQDN: "_mysql._tcp.example.com."}, *dnsCli.Statuses["_mysql._tcp.example.com"])
+	assert.Equal(0, len(dnsCli.Backoffs))
+}
+
+func TestDNSClientDigWithDisableBackoff(t *testing.T) {
+	assert := assert.New(t)
+	var queries int32
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		m := &dns.Msg{}
+		m.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Domains:        []string{"_mysql._tcp.example.com"},
+		Net:            "tcp",
+		Nameservers:    []string{addr},
+		Interval:       3600,
+		BackoffMax:     3600,
+		DisableBackoff: true,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	dnsCli.Dig()
+	dnsCli.Dig()
+	assert.Equal(int32(2), atomic.LoadInt32(&queries))
+	assert.Equal(0, len(dnsCli.Backoffs))
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ServiceInstance struct has the service instance discovered by DNS-SD (RFC 6763).
+type ServiceInstance struct {
+	Name     string
+	FQDN     string
+	Target   string
+	Port     uint16
+	Priority uint16
+	Weight   uint16
+	TXT      map[string]string
+}
+
+// instanceName returns the unescaped first label of the service instance name (e.g. "Web Server" of "Web\ Server._http._tcp.example.com.").
+func instanceName(fqdn string) string {
+	labels := dns.SplitDomainName(fqdn)
+
+	if len(labels) == 0 {
+		return ""
+	}
+
+	label := labels[0]
+	var name strings.Builder
+
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			name.WriteByte(label[i])
+			continue
+		}
+
+		// \DDD is the decimal value of the byte, and \X is the character itself
+		if i+3 < len(label) {
+			if b, err := strconv.ParseUint(label[i+1:i+4], 10, 8); err == nil {
+				name.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+
+		name.WriteByte(label[i+1])
+		i++
+	}
+
+	return name.String()
+}
+
+// parseTXT parses the "key=value" strings of the TXT record (RFC 6763 section 6).
+// The keys are case-insensitive, and only the first occurrence of the key is used.
+// The key without "=" is a boolean attribute and has an empty value.
+func parseTXT(txt []string) (attrs map[string]string) {
+	attrs = map[string]string{}
+
+	for _, s := range txt {
+		kv := strings.SplitN(s, "=", 2)
+		key := strings.ToLower(kv[0])
+
+		if key == "" {
+			continue
+		}
+
+		if _, ok := attrs[key]; ok {
+			continue
+		}
+
+		if len(kv) == 2 {
+			attrs[key] = kv[1]
+		} else {
+			attrs[key] = ""
+		}
+	}
+
+	return
+}
+
+// question returns a copy of the message with the question of the name and the type.
+func question(msg *dns.Msg, name string, qtype uint16) (q *dns.Msg) {
+	q = msg.Copy()
+	q.Id = dns.Id()
+	q.Question[0] = dns.Question{Name: dns.Fqdn(name), Qtype: qtype, Qclass: dns.ClassINET}
+	return
+}
+
+// BrowseCache struct has the service instances cached until the minimum TTL of the PTR, SRV and TXT records expires.
+type BrowseCache struct {
+	Instances []*ServiceInstance
+	ExpiredAt time.Time
+}
+
+// Browse enumerates the instances of the service type (e.g. "_http._tcp.example.com") by PTR records,
+// and resolves the SRV and TXT records of each instance.
+// An instance with multiple SRV records is returned for each SRV record.
+// The instances are cached by the TTL, and the service failed recently is not browsed until the backoff expires.
+func (dnsCli *DNSClient) Browse() (instancesByService map[string][]*ServiceInstance) {
+	instancesByService = make(map[string][]*ServiceInstance, len(dnsCli.Services))
+
+	for service, msg := range dnsCli.Services {
+		if instances, ok := dnsCli.cachedInstances(service); ok {
+			instancesByService[service] = instances
+			continue
+		}
+
+		if dnsCli.backedOff(dnsCli.BrowseBackoffs, service) {
+			instancesByService[service] = []*ServiceInstance{}
+			continue
+		}
+
+		instances, status, ttl := dnsCli.browse(service, msg)
+		instancesByService[service] = instances
+
+		dnsCli.mutex.Lock()
+		dnsCli.recordLookup(dnsCli.BrowseBackoffs, service, status, ttl >= 0)
+		dnsCli.BrowseStatuses[service] = status
+
+		if ttl >= 0 {
+			dnsCli.BrowseCaches[service] = &BrowseCache{
+				Instances: instances,
+				ExpiredAt: time.Now().Add(dnsCli.clampTTL(uint32(ttl))),
+			}
+		}
+
+		dnsCli.mutex.Unlock()
+	}
+
+	return
+}
+
+// cachedInstances returns the cached instances of the service.
+func (dnsCli *DNSClient) cachedInstances(service string) (instances []*ServiceInstance, ok bool) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	entry, ok := dnsCli.BrowseCaches[service]
+
+	if !ok {
+		return
+	}
+
+	if entry.ExpiredAt.Before(time.Now()) {
+		delete(dnsCli.BrowseCaches, service)
+		ok = false
+		return
+	}
+
+	instances = entry.Instances
+	return
+}
+
+// browse returns the instances of the service and the TTL to cache them (-1 if they are not cacheable).
+// The PTR and TXT records are resolved by the first answer, and the SRV records according to resolver_mode.
+// All answers are checked according to the dnssec option.
+func (dnsCli *DNSClient) browse(service string, msg *dns.Msg) (instances []*ServiceInstance, status *DomainStatus, ttl int64) {
+	instances = []*ServiceInstance{}
+	status = &DomainStatus{Reason: DomainReasonNotFound}
+	ttl = -1
+
+	expire := func(t int64) {
+		if t >= 0 && (ttl < 0 || t < ttl) {
+			ttl = t
+		}
+	}
+
+	r, err := dnsCli.resolveMsg(service, msg, status)
+
+	if err != nil {
+		status.Reason = DomainReasonError
+		status.Error = fmt.Sprintf("PTR lookup failed: %s", err)
+		return
+	}
+
+	for _, rr := range r.Answer {
+		ptr, ok := rr.(*dns.PTR)
+
+		if !ok || !strings.EqualFold(ptr.Hdr.Name, msg.Question[0].Name) {
+			continue
+		}
+
+		expire(int64(ptr.Hdr.Ttl))
+		srvs, negTTL, err := dnsCli.resolveSRV(service, question(msg, ptr.Ptr, dns.TypeSRV), status)
+
+		if err != nil {
+			status.Reason = DomainReasonError
+			status.Error = fmt.Sprintf("SRV lookup of %s failed: %s", ptr.Ptr, err)
+			instances = []*ServiceInstance{}
+			ttl = -1
+			return
+		}
+
+		if len(srvs) == 0 || isUnavailable(srvs) {
+			log.Printf("WARNING: %s has no available SRV record", ptr.Ptr)
+			expire(negTTL)
+			continue
+		}
+
+		expire(int64(minTTL(srvs)))
+		txt, txtTTL, err := dnsCli.lookupTXT(service, question(msg, ptr.Ptr, dns.TypeTXT), status)
+
+		if err != nil {
+			status.Reason = DomainReasonError
+			status.Error = fmt.Sprintf("TXT lookup of %s failed: %s", ptr.Ptr, err)
+			instances = []*ServiceInstance{}
+			ttl = -1
+			return
+		}
+
+		expire(txtTTL)
+		sortSRVs(srvs)
+
+		for _, srv := range srvs {
+			instances = append(instances, &ServiceInstance{
+				Name:     instanceName(ptr.Ptr),
+				FQDN:     ptr.Ptr,
+				Target:   srv.Target,
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+				TXT:      txt,
+			})
+		}
+	}
+
+	sort.SliceStable(instances, func(i, j int) bool {
+		return instances[i].Name < instances[j].Name
+	})
+
+	if len(instances) > 0 {
+		status.Reason = DomainReasonOK
+	} else if ttl < 0 && dnsCli.DNSSEC != DNSSECValidate {
+		// The negative answer is cached as the SRV lookup
+		expire(negativeTTL(r))
+	}
+
+	return
+}
+
+// lookupTXT returns the attributes of the first TXT record of the instance and its TTL.
+// An instance without TXT record has no attribute, and the TTL is of the negative answer (-1 if unknown).
+func (dnsCli *DNSClient) lookupTXT(service string, msg *dns.Msg, status *DomainStatus) (attrs map[string]string, ttl int64, err error) {
+	attrs = map[string]string{}
+	r, err := dnsCli.resolveMsg(service, msg, status)
+
+	if err != nil {
+		return
+	}
+
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, msg.Question[0].Name) {
+			attrs = parseTXT(txt.Txt)
+			ttl = int64(txt.Hdr.Ttl)
+			return
+		}
+	}
+
+	ttl = negativeTTL(r)
+	return
+}
+
+// ServiceStatuses returns a copy of the browse results.
+func (dnsCli *DNSClient) ServiceStatuses() (statuses map[string]DomainStatus) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	statuses = make(map[string]DomainStatus, len(dnsCli.BrowseStatuses))
+
+	for service, status := range dnsCli.BrowseStatuses {
+		statuses[service] = *status
+	}
+
+	return
+}
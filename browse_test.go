@@ -0,0 +1,250 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+// dnssdHandler serves _http._tcp.example.com with the instances "Web 1" (with TXT), "web2" (without TXT)
+// and "gone" (without SRV).
+func dnssdHandler(w dns.ResponseWriter, req *dns.Msg) {
+	m := &dns.Msg{}
+	m.SetReply(req)
+	q := req.Question[0]
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: q.Name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: 60}
+	}
+
+	switch {
+	case q.Qtype == dns.TypePTR && q.Name == "_http._tcp.example.com.":
+		for _, instance := range []string{"web2", `Web\ 1`, "gone"} {
+			m.Answer = append(m.Answer, &dns.PTR{Hdr: hdr(dns.TypePTR), Ptr: instance + "._http._tcp.example.com."})
+		}
+	case q.Qtype == dns.TypeSRV && strings.HasPrefix(q.Name, `Web\ 1.`):
+		m.Answer = []dns.RR{&dns.SRV{Hdr: hdr(dns.TypeSRV), Priority: 10, Weight: 100, Port: 8080, Target: "host1.example.com."}}
+	case q.Qtype == dns.TypeSRV && strings.HasPrefix(q.Name, "web2."):
+		m.Answer = []dns.RR{
+			&dns.SRV{Hdr: hdr(dns.TypeSRV), Priority: 20, Weight: 100, Port: 80, Target: "host3.example.com."},
+			&dns.SRV{Hdr: hdr(dns.TypeSRV), Priority: 10, Weight: 100, Port: 80, Target: "host2.example.com."},
+		}
+	case q.Qtype == dns.TypeTXT && strings.HasPrefix(q.Name, `Web\ 1.`):
+		m.Answer = []dns.RR{&dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: []string{"path=/api", "Secure", "PATH=/ignored", "", "=novalue"}}}
+	case q.Qtype == dns.TypeTXT && strings.HasPrefix(q.Name, "web2."):
+	default:
+		m.Rcode = dns.RcodeNameError
+	}
+
+	w.WriteMsg(m)
+}
+
+func TestDNSClientBrowse(t *testing.T) {
+	assert := assert.New(t)
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(dnssdHandler), nil)
+	defer shutdown()
+
+	config := &Config{
+		Browse:      []string{"_http._tcp.example.com", "_ftp._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	instancesByService := dnsCli.Browse()
+	assert.Equal(0, len(instancesByService["_ftp._tcp.example.com"]))
+	instances := instancesByService["_http._tcp.example.com"]
+	assert.Equal(3, len(instances))
+
+	assert.Equal(ServiceInstance{
+		Name:     "Web 1",
+		FQDN:     `Web\ 1._http._tcp.example.com.`,
+		Target:   "host1.example.com.",
+		Port:     8080,
+		Priority: 10,
+		Weight:   100,
+		TXT:      map[string]string{"path": "/api", "secure": ""},
+	}, *instances[0])
+
+	assert.Equal("web2", instances[1].Name)
+	assert.Equal("host2.example.com.", instances[1].Target)
+	assert.Equal(map[string]string{}, instances[1].TXT)
+	assert.Equal("host3.example.com.", instances[2].Target)
+
+	statuses := dnsCli.ServiceStatuses()
+	assert.Equal(DomainReasonOK, statuses["_http._tcp.example.com"].Reason)
+	assert.Equal(DomainReasonNotFound, statuses["_ftp._tcp.example.com"].Reason)
+}
+
+func TestDNSClientBrowseFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	// Connection to the closed port is refused
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	config := &Config{
+		Browse:      []string{"_http._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+		Interval:    3600,
+		BackoffMax:  3600,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	assert.Equal([]*ServiceInstance{}, dnsCli.Browse()["_http._tcp.example.com"])
+	status := dnsCli.ServiceStatuses()["_http._tcp.example.com"]
+	assert.Equal(DomainReasonError, status.Reason)
+	assert.Contains(status.Error, "PTR lookup failed: ")
+	assert.Equal(uint64(1), status.ConsecutiveFailures)
+	assert.Equal(0, len(dnsCli.BrowseCaches))
+	assert.True(dnsCli.backedOff(dnsCli.BrowseBackoffs, "_http._tcp.example.com"))
+}
+
+func TestDNSClientBrowseWithCache(t *testing.T) {
+	assert := assert.New(t)
+	queries := 0
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		queries++
+		dnssdHandler(w, req)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Browse:      []string{"_http._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+		MaxTTL:      30,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	instances := dnsCli.Browse()["_http._tcp.example.com"]
+	assert.Equal(3, len(instances))
+	// PTR + SRV * 3 + TXT * 2
+	assert.Equal(6, queries)
+
+	// The instances are cached by the minimum TTL
+	assert.Equal(instances, dnsCli.Browse()["_http._tcp.example.com"])
+	assert.Equal(6, queries)
+	expiredAt := dnsCli.BrowseCaches["_http._tcp.example.com"].ExpiredAt
+	assert.True(expiredAt.After(time.Now().Add(29 * time.Second)))
+	assert.True(expiredAt.Before(time.Now().Add(31 * time.Second)))
+
+	dnsCli.BrowseCaches["_http._tcp.example.com"].ExpiredAt = time.Now().Add(-time.Second)
+	assert.Equal(3, len(dnsCli.Browse()["_http._tcp.example.com"]))
+	assert.Equal(12, queries)
+}
+
+func TestDNSClientBrowseWithDNSSECRequireAD(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		// Only the SRV records are authenticated
+		recorder := &authenticatedWriter{ResponseWriter: w, authenticated: req.Question[0].Qtype == dns.TypeSRV}
+		dnssdHandler(recorder, req)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Browse:      []string{"_http._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+		DNSSEC:      DNSSECRequireAD,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	assert.Equal([]*ServiceInstance{}, dnsCli.Browse()["_http._tcp.example.com"])
+	status := dnsCli.ServiceStatuses()["_http._tcp.example.com"]
+	assert.Equal(DomainReasonError, status.Reason)
+	assert.Equal("PTR lookup failed: The response from "+addr+" does not have the AD flag", status.Error)
+	assert.Equal(DNSSECStatusInsecure, status.DNSSEC)
+}
+
+func TestDNSClientBrowseWithDNSSECValidate(t *testing.T) {
+	assert := assert.New(t)
+	example := newTestSignedZone("example.com.")
+
+	for unsigned, message := range map[uint16]string{
+		dns.TypeNone: "",
+		dns.TypePTR:  "PTR lookup failed: DNSSEC validation failed: _http._tcp.example.com./PTR is not signed",
+		dns.TypeTXT:  `TXT lookup of Web\ 1._http._tcp.example.com. failed: DNSSEC validation failed: web\ 1._http._tcp.example.com./TXT is not signed`,
+	} {
+		addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			if req.Question[0].Qtype == dns.TypeDNSKEY {
+				m := &dns.Msg{}
+				m.SetReply(req)
+				m.Answer = []dns.RR{example.key, example.sign([]dns.RR{example.key})}
+				w.WriteMsg(m)
+				return
+			}
+
+			dnssdHandler(&signingWriter{ResponseWriter: w, zone: example, unsigned: unsigned}, req)
+		}), nil)
+
+		config := &Config{
+			Browse:       []string{"_http._tcp.example.com"},
+			Net:          "tcp",
+			Nameservers:  []string{addr},
+			DNSSEC:       DNSSECValidate,
+			TrustAnchors: []string{example.key.ToDS(dns.SHA256).String()},
+		}
+
+		dnsCli, _ := NewDNSClient(config)
+		instances := dnsCli.Browse()["_http._tcp.example.com"]
+		status := dnsCli.ServiceStatuses()["_http._tcp.example.com"]
+
+		if message == "" {
+			assert.Equal(3, len(instances))
+			assert.Equal(DomainReasonOK, status.Reason)
+		} else {
+			assert.Equal([]*ServiceInstance{}, instances)
+			assert.Equal(DomainReasonError, status.Reason)
+			assert.Equal(message, status.Error)
+			assert.Equal(DNSSECStatusBogus, status.DNSSEC)
+		}
+
+		shutdown()
+	}
+}
+
+// signingWriter signs the answer of the response with the zone key unless the question is of the unsigned type.
+type signingWriter struct {
+	dns.ResponseWriter
+	zone     *testSignedZone
+	unsigned uint16
+}
+
+func (w *signingWriter) WriteMsg(m *dns.Msg) error {
+	if len(m.Answer) > 0 && m.Question[0].Qtype != w.unsigned {
+		m.Answer = append(m.Answer, w.zone.sign(m.Answer))
+	}
+
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// authenticatedWriter sets the AD flag of the response.
+type authenticatedWriter struct {
+	dns.ResponseWriter
+	authenticated bool
+}
+
+func (w *authenticatedWriter) WriteMsg(m *dns.Msg) error {
+	m.AuthenticatedData = w.authenticated
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+func TestInstanceName(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("Web Server", instanceName(`Web\ Server._http._tcp.example.com.`))
+	assert.Equal("Web Server", instanceName(`Web\032Server._http._tcp.example.com.`))
+	assert.Equal("a.b", instanceName(`a\.b._http._tcp.example.com.`))
+	assert.Equal("", instanceName("."))
+}
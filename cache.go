@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// MinRefreshWait is the minimum time to wait for the next lookup with ttl_refresh.
+	MinRefreshWait = time.Second
+)
+
+// minTTL returns the minimum TTL across the SRV records.
+func minTTL(srvs []*dns.SRV) (ttl uint32) {
+	for i, srv := range srvs {
+		if i == 0 || srv.Hdr.Ttl < ttl {
+			ttl = srv.Hdr.Ttl
+		}
+	}
+
+	return
+}
+
+// negativeTTL returns the TTL to cache the negative answer (RFC 2308).
+// It is the minimum of the SOA TTL and the SOA MINIMUM field, or -1 if the answer has no SOA record.
+func negativeTTL(r *dns.Msg) (ttl int64) {
+	ttl = -1
+
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl = int64(soa.Hdr.Ttl)
+
+			if int64(soa.Minttl) < ttl {
+				ttl = int64(soa.Minttl)
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+// clampTTL clamps the TTL with min_ttl and max_ttl.
+func (dnsCli *DNSClient) clampTTL(ttl uint32) time.Duration {
+	if dnsCli.MinTTL > 0 && ttl < uint32(dnsCli.MinTTL) {
+		ttl = uint32(dnsCli.MinTTL)
+	}
+
+	if dnsCli.MaxTTL > 0 && ttl > uint32(dnsCli.MaxTTL) {
+		ttl = uint32(dnsCli.MaxTTL)
+	}
+
+	return time.Duration(ttl) * time.Second
+}
+
+// cache caches the entry for the TTL.
+// The caller must hold the mutex.
+// With ttl_refresh, the domain is refreshed after the TTL expires plus random jitter.
+func (dnsCli *DNSClient) cache(domain string, entry *SRVCache, ttl time.Duration) {
+	entry.TTL = ttl
+	entry.ExpiredAt = time.Now().Add(ttl)
+	entry.RefreshAt = entry.ExpiredAt
+
+	if dnsCli.TTLJitter > 0 {
+		entry.RefreshAt = entry.RefreshAt.Add(time.Duration(rand.Int63n(int64(dnsCli.TTLJitter))))
+	}
+
+	dnsCli.Cache[domain] = entry
+}
+
+// NextRefresh returns the time to wait for the next lookup.
+// Without ttl_refresh, it is the interval.
+// With ttl_refresh, it is the time until the earliest refresh of the cached domains and browsed services.
+// With prefetch, the refresh of the domain is at the beginning of the prefetch window.
+// The domains not cached (e.g. lookup failed) are retried after the interval, or when the backoff expires.
+func (dnsCli *DNSClient) NextRefresh(interval time.Duration) (wait time.Duration) {
+	if !dnsCli.TTLRefresh {
+		return interval
+	}
+
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+
+	now := time.Now()
+	var next time.Time
+
+	for domain := range dnsCli.Messages {
+		refreshAt := now.Add(interval)
+
+		if entry, ok := dnsCli.Cache[domain]; ok {
+			refreshAt = entry.RefreshAt
+
+			// With prefetch, the worker wakes when the entry enters the prefetch window so that it is refreshed before expiring
+			if dnsCli.Prefetch > 0 && !entry.prefetching {
+				refreshAt = entry.ExpiredAt.Add(-time.Duration(float64(entry.TTL) * dnsCli.Prefetch))
+			}
+		} else if backoff, ok := dnsCli.Backoffs[domain]; ok {
+			refreshAt = backoff.RetryAt
+		}
+
+		if next.IsZero() || refreshAt.Before(next) {
+			next = refreshAt
+		}
+	}
+
+	for service := range dnsCli.Services {
+		refreshAt := now.Add(interval)
+
+		if entry, ok := dnsCli.BrowseCaches[service]; ok {
+			refreshAt = entry.ExpiredAt
+		} else if backoff, ok := dnsCli.BrowseBackoffs[service]; ok {
+			refreshAt = backoff.RetryAt
+		}
+
+		if next.IsZero() || refreshAt.Before(next) {
+			next = refreshAt
+		}
+	}
+
+	// No domain (e.g. only the transferred zone)
+	if next.IsZero() {
+		return interval
+	}
+
+	wait = next.Sub(now)
+
+	if wait < MinRefreshWait {
+		wait = MinRefreshWait
+	}
+
+	return
+}
+
+// cached returns the cached SRV records of the domain.
+// With prefetch, the entry within the last fraction of its TTL is refreshed in the background while the cached value is served.
+func (dnsCli *DNSClient) cached(domain string, msg *dns.Msg) (srvs []*dns.SRV, ok bool) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	entry, ok := dnsCli.Cache[domain]
+
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	if entry.ExpiredAt.Before(now) {
+		delete(dnsCli.Cache, domain)
+
+		if dnsCli.Prefetch > 0 {
+			dnsCli.Metrics.PrefetchMisses++
+		}
+
+		ok = false
+		return
+	}
+
+	if entry.Prefetched {
+		dnsCli.Metrics.PrefetchHits++
+		entry.Prefetched = false
+	}
+
+	if dnsCli.Prefetch > 0 && !entry.prefetching && entry.ExpiredAt.Sub(now) <= time.Duration(float64(entry.TTL)*dnsCli.Prefetch) {
+		entry.prefetching = true
+		dnsCli.Metrics.Prefetches++
+		dnsCli.prefetches.Add(1)
+
+		go func() {
+			defer dnsCli.prefetches.Done()
+			dnsCli.lookup(domain, msg, true)
+		}()
+	}
+
+	srvs = entry.SRVs
+	return
+}
+
+// Wait waits for the background prefetches to finish.
+func (dnsCli *DNSClient) Wait() {
+	dnsCli.prefetches.Wait()
+}
+
+// CurrentMetrics returns a copy of the metrics.
+func (dnsCli *DNSClient) CurrentMetrics() Metrics {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	return dnsCli.Metrics
+}
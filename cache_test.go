@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func TestMinTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	srvs := []*dns.SRV{
+		&dns.SRV{Target: "server1.example.com.", Hdr: dns.RR_Header{Ttl: 30}},
+		&dns.SRV{Target: "server2.example.com.", Hdr: dns.RR_Header{Ttl: 5}},
+		&dns.SRV{Target: "server3.example.com.", Hdr: dns.RR_Header{Ttl: 60}},
+	}
+
+	assert.Equal(uint32(5), minTTL(srvs))
+}
+
+func TestNegativeTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &dns.Msg{}
+	assert.Equal(int64(-1), negativeTTL(r))
+
+	r.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Ttl: 300}, Minttl: 60}}
+	assert.Equal(int64(60), negativeTTL(r))
+
+	r.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Ttl: 30}, Minttl: 60}}
+	assert.Equal(int64(30), negativeTTL(r))
+}
+
+func TestClampTTL(t *testing.T) {
+	assert := assert.New(t)
+	dnsCli := &DNSClient{MinTTL: 10, MaxTTL: 60}
+	assert.Equal(10*time.Second, dnsCli.clampTTL(0))
+	assert.Equal(30*time.Second, dnsCli.clampTTL(30))
+	assert.Equal(60*time.Second, dnsCli.clampTTL(3600))
+
+	dnsCli = &DNSClient{}
+	assert.Equal(3600*time.Second, dnsCli.clampTTL(3600))
+}
+
+func TestDNSClientDigWithMinTTLOfRRset(t *testing.T) {
+	assert := assert.New(t)
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(req)
+
+		m.Answer = []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 300}},
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 20}},
+		}
+
+		w.WriteMsg(m)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+		MaxTTL:      10,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	dnsCli.Dig()
+	entry := dnsCli.Cache["_mysql._tcp.example.com"]
+	assert.InDelta(10, entry.ExpiredAt.Sub(time.Now()).Seconds(), 0.5)
+	assert.Equal(entry.ExpiredAt, entry.RefreshAt)
+
+	config.MaxTTL = 0
+	dnsCli, _ = NewDNSClient(config)
+	dnsCli.Dig()
+	entry = dnsCli.Cache["_mysql._tcp.example.com"]
+	assert.InDelta(20, entry.ExpiredAt.Sub(time.Now()).Seconds(), 0.5)
+}
+
+func TestDNSClientDigWithNegativeCache(t *testing.T) {
+	assert := assert.New(t)
+	counter := 0
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		counter++
+		m := &dns.Msg{}
+		m.SetRcode(req, dns.RcodeNameError)
+		m.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300}, Ns: "ns.example.com.", Mbox: "admin.example.com.", Minttl: 60}}
+		w.WriteMsg(m)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	assert.Equal([]*dns.SRV{}, dnsCli.Dig()["_mysql._tcp.example.com"])
+	assert.Equal([]*dns.SRV{}, dnsCli.Dig()["_mysql._tcp.example.com"])
+	assert.Equal(1, counter)
+
+	entry := dnsCli.Cache["_mysql._tcp.example.com"]
+	assert.True(entry.Negative)
+	assert.InDelta(60, entry.ExpiredAt.Sub(time.Now()).Seconds(), 0.5)
+	assert.Equal(DomainReasonNotFound, dnsCli.Statuses["_mysql._tcp.example.com"].Reason)
+}
+
+func TestDNSClientNextRefresh(t *testing.T) {
+	assert := assert.New(t)
+	interval := 30 * time.Second
+
+	dnsCli := &DNSClient{
+		Messages: map[string]*dns.Msg{"_mysql._tcp.example.com": &dns.Msg{}, "_http._tcp.example.com": &dns.Msg{}},
+		Cache:    map[string]*SRVCache{},
+	}
+
+	assert.Equal(interval, dnsCli.NextRefresh(interval))
+
+	dnsCli.TTLRefresh = true
+	dnsCli.cache("_mysql._tcp.example.com", &SRVCache{}, 10*time.Second)
+	dnsCli.cache("_http._tcp.example.com", &SRVCache{}, 60*time.Second)
+	assert.InDelta(10, dnsCli.NextRefresh(interval).Seconds(), 0.5)
+
+	// The domain not cached is retried after the interval
+	delete(dnsCli.Cache, "_mysql._tcp.example.com")
+	assert.InDelta(30, dnsCli.NextRefresh(interval).Seconds(), 0.5)
+
+	// Zero TTL does not make a busy loop
+	dnsCli.cache("_mysql._tcp.example.com", &SRVCache{}, 0)
+	assert.Equal(MinRefreshWait, dnsCli.NextRefresh(interval))
+
+	dnsCli.TTLJitter = 5 * time.Second
+
+	for i := 0; i < 10; i++ {
+		dnsCli.cache("_mysql._tcp.example.com", &SRVCache{}, 10*time.Second)
+		entry := dnsCli.Cache["_mysql._tcp.example.com"]
+		jitter := entry.RefreshAt.Sub(entry.ExpiredAt)
+		assert.True(jitter >= 0 && jitter < 5*time.Second)
+	}
+}
+
+func TestDNSClientNextRefreshWithPrefetch(t *testing.T) {
+	assert := assert.New(t)
+	interval := 30 * time.Second
+
+	dnsCli := &DNSClient{
+		Messages:   map[string]*dns.Msg{"_mysql._tcp.example.com": &dns.Msg{}},
+		Cache:      map[string]*SRVCache{},
+		TTLRefresh: true,
+		TTLJitter:  5 * time.Second,
+		Prefetch:   0.2,
+	}
+
+	// The worker wakes at the beginning of the prefetch window instead of the expiration
+	dnsCli.cache("_mysql._tcp.example.com", &SRVCache{}, 20*time.Second)
+	assert.InDelta(16, dnsCli.NextRefresh(interval).Seconds(), 0.5)
+
+	// The entry being prefetched is refreshed after it expires
+	dnsCli.Cache["_mysql._tcp.example.com"].prefetching = true
+	assert.True(dnsCli.NextRefresh(interval) >= 19*time.Second)
+}
+
+func TestDNSClientDigWithPrefetch(t *testing.T) {
+	assert := assert.New(t)
+	counter := 0
+	mutex := sync.Mutex{}
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mutex.Lock()
+		counter++
+		target := fmt.Sprintf("server%d.example.com.", counter)
+		mutex.Unlock()
+
+		m := &dns.Msg{}
+		m.SetReply(req)
+
+		m.Answer = []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: target, Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 10}},
+		}
+
+		w.WriteMsg(m)
+	}), nil)
+
+	defer shutdown()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{addr},
+		Prefetch:    0.2,
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	assert.Equal("server1.example.com.", dnsCli.Dig()["_mysql._tcp.example.com"][0].Target)
+
+	// Not within the last 20% of the TTL
+	assert.Equal("server1.example.com.", dnsCli.Dig()["_mysql._tcp.example.com"][0].Target)
+	dnsCli.Wait()
+	assert.Equal(uint64(0), dnsCli.CurrentMetrics().Prefetches)
+
+	// The cached value is served while it is refreshed in the background
+	dnsCli.Cache["_mysql._tcp.example.com"].ExpiredAt = time.Now().Add(time.Second)
+	assert.Equal("server1.example.com.", dnsCli.Dig()["_mysql._tcp.example.com"][0].Target)
+	dnsCli.Wait()
+	assert.Equal("server2.example.com.", dnsCli.Dig()["_mysql._tcp.example.com"][0].Target)
+	assert.Equal(Metrics{Prefetches: 1, PrefetchHits: 1}, dnsCli.CurrentMetrics())
+
+	// The expired entry is a miss
+	dnsCli.Cache["_mysql._tcp.example.com"].ExpiredAt = time.Now().Add(-time.Second)
+	assert.Equal("server3.example.com.", dnsCli.Dig()["_mysql._tcp.example.com"][0].Target)
+	assert.Equal(Metrics{Prefetches: 1, PrefetchHits: 1, PrefetchMisses: 1}, dnsCli.CurrentMetrics())
+}
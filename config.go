@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/BurntSushi/toml"
+	"github.com/miekg/dns"
 )
 
 const (
@@ -12,13 +14,23 @@ const (
 	DefaultStatusPort = 8080
 	// DefaultEdns0Size is the default edns0_size value.
 	DefaultEdns0Size = 4096
+	// NetAuto is the net value which queries over UDP and retries over TCP when the response is truncated.
+	NetAuto = "auto"
+	// EngineSigil is the template engine using sigil.
+	EngineSigil = "sigil"
+	// EngineGoTemplate is the template engine using text/template.
+	EngineGoTemplate = "gotemplate"
 )
 
 // Config struct has the setting of srvd.
 type Config struct {
 	Src                            string
+	Engine                         string
+	TemplateSandbox                bool   `toml:"template_sandbox"`
+	TemplateDir                    string `toml:"template_dir"`
 	Dest                           string
 	Domains                        []string
+	Browse                         []string
 	ResolvConf                     string `toml:"resolv_conf"`
 	ReloadCmd                      string `toml:"reload_cmd"`
 	CheckCmd                       string `toml:"check_cmd"`
@@ -35,6 +47,43 @@ type Config struct {
 	DisableRollbackOnReloadFailure bool   `toml:"disable_rollback_on_reload_failure"`
 	Edns0Size                      uint16 `toml:"edns0_size"`
 	Net                            string
+	Watch                          bool
+	Vars                           map[string]interface{}
+	VarsFile                       string `toml:"vars_file"`
+	TLS                            TLSConfig
+	DoHURL                         string   `toml:"doh_url"`
+	DoHMethod                      string   `toml:"doh_method"`
+	TSIGName                       string   `toml:"tsig_name"`
+	TSIGAlgorithm                  string   `toml:"tsig_algorithm"`
+	TSIGSecret                     string   `toml:"tsig_secret"`
+	TSIGSecretFile                 string   `toml:"tsig_secret_file"`
+	DNSSEC                         string   `toml:"dnssec"`
+	TrustAnchors                   []string `toml:"trust_anchors"`
+	TrustAnchorFile                string   `toml:"trust_anchor_file"`
+	DNSTimeout                     int      `toml:"dns_timeout"`
+	DNSAttempts                    int      `toml:"dns_attempts"`
+	DNSRotate                      *bool    `toml:"dns_rotate"`
+	Nameservers                    []string
+	ResolverMode                   string `toml:"resolver_mode"`
+	MinTTL                         int    `toml:"min_ttl"`
+	MaxTTL                         int    `toml:"max_ttl"`
+	TTLRefresh                     bool   `toml:"ttl_refresh"`
+	TTLJitter                      int    `toml:"ttl_jitter"`
+	Prefetch                       float64
+	BackoffMax                     int  `toml:"backoff_max"`
+	DisableBackoff                 bool `toml:"disable_backoff"`
+	Splay                          int
+	Domain                         map[string]DomainConfig
+	Notify                         NotifyConfig
+	Transfer                       TransferConfig
+}
+
+// DomainConfig struct has the resolver settings of the domain ([domain."name"]).
+type DomainConfig struct {
+	Nameservers []string
+	Net         string
+	Edns0Size   uint16 `toml:"edns0_size"`
+	Timeout     int
 }
 
 // LoadConfig creates Config struct from the given flags.
@@ -59,6 +108,13 @@ func LoadConfig(flags *Flags) (config *Config, err error) {
 		return
 	}
 
+	if config.Engine == "" {
+		config.Engine = EngineSigil
+	} else if config.Engine != EngineSigil && config.Engine != EngineGoTemplate {
+		err = fmt.Errorf("engine must be '%s' or '%s'", EngineSigil, EngineGoTemplate)
+		return
+	}
+
 	if config.Dest == "" {
 		err = fmt.Errorf("dest is required")
 		return
@@ -69,8 +125,9 @@ func LoadConfig(flags *Flags) (config *Config, err error) {
 		return
 	}
 
-	if len(config.Domains) == 0 {
-		err = fmt.Errorf("domains is required")
+	// The SRV records of the transferred zone or the browsed services can be used without domains
+	if len(config.Domains) == 0 && config.Transfer.Zone == "" && len(config.Browse) == 0 {
+		err = fmt.Errorf("domains, transfer.zone or browse is required")
 		return
 	}
 
@@ -93,6 +150,16 @@ func LoadConfig(flags *Flags) (config *Config, err error) {
 		return
 	}
 
+	if config.DNSTimeout < 0 {
+		err = fmt.Errorf("dns_timeout must be '>= 0'")
+		return
+	}
+
+	if config.DNSAttempts < 0 {
+		err = fmt.Errorf("dns_attempts must be '>= 0'")
+		return
+	}
+
 	if config.StatusPort == 0 {
 		config.StatusPort = DefaultStatusPort
 	} else if config.StatusPort < 0 || config.StatusPort > 65535 {
@@ -100,9 +167,202 @@ func LoadConfig(flags *Flags) (config *Config, err error) {
 		return
 	}
 
+	if config.DoHURL != "" {
+		if !strings.HasPrefix(config.DoHURL, "https://") && !strings.HasPrefix(config.DoHURL, "http://") {
+			err = fmt.Errorf("doh_url must be a HTTP(S) URL")
+			return
+		}
+
+		if config.DoHMethod == "" {
+			config.DoHMethod = "POST"
+		} else if config.DoHMethod != "GET" && config.DoHMethod != "POST" {
+			err = fmt.Errorf("doh_method must be 'GET' or 'POST'")
+			return
+		}
+	}
+
+	if len(config.Nameservers) > 0 && config.DoHURL != "" {
+		err = fmt.Errorf("nameservers cannot be used with doh_url")
+		return
+	}
+
+	if config.MinTTL < 0 || config.MaxTTL < 0 || config.TTLJitter < 0 {
+		err = fmt.Errorf("min_ttl, max_ttl and ttl_jitter must be '>= 0'")
+		return
+	}
+
+	if config.Prefetch < 0 || config.Prefetch >= 1 {
+		err = fmt.Errorf("prefetch must be '>= 0' && '< 1'")
+		return
+	}
+
+	if config.BackoffMax < 0 || config.Splay < 0 {
+		err = fmt.Errorf("backoff_max and splay must be '>= 0'")
+		return
+	}
+
+	if config.BackoffMax == 0 {
+		config.BackoffMax = DefaultBackoffMax
+	}
+
+	if config.MaxTTL > 0 && config.MaxTTL < config.MinTTL {
+		err = fmt.Errorf("max_ttl must be '>= min_ttl'")
+		return
+	}
+
+	switch config.ResolverMode {
+	case "":
+		config.ResolverMode = ResolverModeFirst
+	case ResolverModeFirst, ResolverModeAllAgree, ResolverModeMajority, ResolverModeUnion:
+	default:
+		err = fmt.Errorf("resolver_mode must be '%s', '%s', '%s' or '%s'", ResolverModeFirst, ResolverModeAllAgree, ResolverModeMajority, ResolverModeUnion)
+		return
+	}
+
+	err = validateDomainConfigs(config)
+
+	if err != nil {
+		return
+	}
+
+	err = validateTransferConfig(&config.Transfer)
+
+	if err != nil {
+		return
+	}
+
+	err = validateNotifyConfig(&config.Notify)
+
+	if err != nil {
+		return
+	}
+
+	if config.TSIGName != "" {
+		if config.DoHURL != "" {
+			err = fmt.Errorf("tsig_name cannot be used with doh_url")
+			return
+		}
+
+		config.TSIGName = dns.Fqdn(strings.ToLower(config.TSIGName))
+
+		if config.TSIGAlgorithm == "" {
+			config.TSIGAlgorithm = DefaultTSIGAlgorithm
+		} else {
+			config.TSIGAlgorithm = dns.Fqdn(strings.ToLower(config.TSIGAlgorithm))
+		}
+
+		if !TSIGAlgorithms[config.TSIGAlgorithm] {
+			err = fmt.Errorf("Unsupported tsig_algorithm: %s", config.TSIGAlgorithm)
+			return
+		}
+
+		if config.TSIGSecretFile != "" {
+			config.TSIGSecret, err = LoadTSIGSecret(config.TSIGSecretFile)
+
+			if err != nil {
+				err = fmt.Errorf("tsig_secret_file loading failed: %s", err)
+				return
+			}
+		}
+
+		if config.TSIGSecret == "" {
+			err = fmt.Errorf("tsig_secret or tsig_secret_file is required")
+			return
+		}
+	}
+
+	if config.DNSSEC == "" {
+		config.DNSSEC = DNSSECOff
+	} else if config.DNSSEC != DNSSECOff && config.DNSSEC != DNSSECRequireAD && config.DNSSEC != DNSSECValidate {
+		err = fmt.Errorf("dnssec must be '%s', '%s' or '%s'", DNSSECOff, DNSSECRequireAD, DNSSECValidate)
+		return
+	}
+
+	if config.TrustAnchorFile != "" {
+		anchors, e := LoadTrustAnchorFile(config.TrustAnchorFile)
+
+		if e != nil {
+			err = fmt.Errorf("trust_anchor_file loading failed: %s", e)
+			return
+		}
+
+		config.TrustAnchors = append(config.TrustAnchors, anchors...)
+	}
+
+	if config.DNSSEC == DNSSECValidate {
+		if len(config.TrustAnchors) == 0 {
+			err = fmt.Errorf("trust_anchors or trust_anchor_file is required when dnssec is '%s'", DNSSECValidate)
+			return
+		}
+
+		_, err = parseTrustAnchors(config.TrustAnchors)
+
+		if err != nil {
+			return
+		}
+	}
+
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		err = fmt.Errorf("tls.cert_file and tls.key_file must be specified together")
+		return
+	}
+
 	if config.Edns0Size < 1 {
 		config.Edns0Size = DefaultEdns0Size
 	}
 
+	vars := map[string]interface{}{}
+
+	if config.VarsFile != "" {
+		vars, err = LoadVarsFile(config.VarsFile)
+
+		if err != nil {
+			err = fmt.Errorf("vars_file loading failed: %s", err)
+			return
+		}
+	}
+
+	// [vars] takes precedence over vars_file
+	for k, v := range config.Vars {
+		vars[k] = v
+	}
+
+	config.Vars = ExpandVars(vars).(map[string]interface{})
+
+	return
+}
+
+// validateDomainConfigs validates [domain."name"] tables.
+func validateDomainConfigs(config *Config) (err error) {
+	domains := map[string]bool{}
+
+	for _, domain := range config.Domains {
+		domains[domain] = true
+	}
+
+	for domain, domainConfig := range config.Domain {
+		if !domains[domain] {
+			err = fmt.Errorf("domain.%q is not in domains", domain)
+			return
+		}
+
+		switch domainConfig.Net {
+		case "", NetAuto, "udp", "tcp", "tcp-tls":
+		default:
+			err = fmt.Errorf("domain.%q.net must be 'auto', 'udp', 'tcp' or 'tcp-tls'", domain)
+			return
+		}
+
+		if config.DoHURL != "" && (len(domainConfig.Nameservers) > 0 || domainConfig.Net != "") {
+			err = fmt.Errorf("domain.%q.nameservers and domain.%q.net cannot be used with doh_url", domain, domain)
+			return
+		}
+
+		if domainConfig.Timeout < 0 {
+			err = fmt.Errorf("domain.%q.timeout must be '>= 0'", domain)
+			return
+		}
+	}
+
 	return
 }
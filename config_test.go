@@ -4,6 +4,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 	"github.com/winebarrel/srvd/testutils"
 )
@@ -40,6 +41,53 @@ timeout = 2
 		assert.Equal(false, config.DisableRollbackOnReloadFailure)
 		assert.Equal(uint16(4096), config.Edns0Size)
 		assert.Equal("", config.Net)
+		assert.Equal(false, config.Watch)
+		assert.Equal("", config.TemplateDir)
+		assert.Equal("sigil", config.Engine)
+		assert.Equal(false, config.TemplateSandbox)
+		assert.Equal(300, config.BackoffMax)
+		assert.Equal(false, config.DisableBackoff)
+		assert.Equal(0, config.Splay)
+		assert.Equal(map[string]interface{}{}, config.Vars)
+	})
+}
+
+func TestLoadConfigWithVars(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+	os.Setenv("SRVD_TEST_MAXCONN", "200")
+	defer os.Unsetenv("SRVD_TEST_MAXCONN")
+
+	testutils.TempFile(`{"port": 3306, "maxconn": "100", "name": "db"}`, func(varsFile *os.File) {
+		os.Rename(varsFile.Name(), varsFile.Name()+".json")
+		defer os.Remove(varsFile.Name() + ".json")
+
+		conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+vars_file = "` + varsFile.Name() + `.json"
+
+[vars]
+maxconn = "${SRVD_TEST_MAXCONN}"
+bind = "*:80"
+`
+
+		testutils.TempFile(conf, func(f *os.File) {
+			flags.Config = f.Name()
+			config, err := LoadConfig(flags)
+			assert.Equal(nil, err)
+
+			assert.Equal(map[string]interface{}{
+				"port":    float64(3306),
+				"maxconn": "200",
+				"name":    "db",
+				"bind":    "*:80",
+			}, config.Vars)
+		})
 	})
 }
 
@@ -62,6 +110,20 @@ sdnotify = true
 disable_rollback_on_reload_failure = true
 edns0_size = 2048
 net = "udp"
+watch = true
+template_dir = "templates"
+engine = "gotemplate"
+template_sandbox = true
+backoff_max = 60
+disable_backoff = true
+splay = 30
+
+[tls]
+server_name = "dns.example.com"
+ca_file = "ca.pem"
+cert_file = "cert.pem"
+key_file = "key.pem"
+insecure_skip_verify = true
 `
 
 	testutils.TempFile(conf, func(f *os.File) {
@@ -83,6 +145,21 @@ net = "udp"
 		assert.Equal(true, config.DisableRollbackOnReloadFailure)
 		assert.Equal(uint16(2048), config.Edns0Size)
 		assert.Equal("udp", config.Net)
+		assert.Equal(true, config.Watch)
+		assert.Equal("templates", config.TemplateDir)
+		assert.Equal("gotemplate", config.Engine)
+		assert.Equal(true, config.TemplateSandbox)
+		assert.Equal(60, config.BackoffMax)
+		assert.Equal(true, config.DisableBackoff)
+		assert.Equal(30, config.Splay)
+
+		assert.Equal(TLSConfig{
+			ServerName:         "dns.example.com",
+			CAFile:             "ca.pem",
+			CertFile:           "cert.pem",
+			KeyFile:            "key.pem",
+			InsecureSkipVerify: true,
+		}, config.TLS)
 	})
 }
 
@@ -162,7 +239,7 @@ timeout = 2
 	testutils.TempFile(conf, func(f *os.File) {
 		flags.Config = f.Name()
 		_, err := LoadConfig(flags)
-		assert.Equal("domains is required", err.Error())
+		assert.Equal("domains, transfer.zone or browse is required", err.Error())
 	})
 }
 
@@ -246,3 +323,468 @@ status_port = -1
 		assert.Equal("status_port mult be '>= 0' && '<= 65535'", err.Error())
 	})
 }
+
+func TestLoadConfigWithInvalidEngine(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+engine = "erb"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("engine must be 'sigil' or 'gotemplate'", err.Error())
+	})
+}
+
+func TestLoadConfigWithoutTLSKeyFile(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+
+[tls]
+cert_file = "cert.pem"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("tls.cert_file and tls.key_file must be specified together", err.Error())
+	})
+}
+
+func TestLoadConfigWithDoH(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+doh_url = "https://resolver.internal/dns-query"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		config, err := LoadConfig(flags)
+		assert.Equal(nil, err)
+		assert.Equal("https://resolver.internal/dns-query", config.DoHURL)
+		assert.Equal("POST", config.DoHMethod)
+	})
+}
+
+func TestLoadConfigWithInvalidDoHMethod(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+doh_url = "https://resolver.internal/dns-query"
+doh_method = "PUT"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("doh_method must be 'GET' or 'POST'", err.Error())
+	})
+}
+
+func TestLoadConfigWithTSIG(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+tsig_name = "SRVD-Key"
+tsig_secret = "c2VjcmV0"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		config, err := LoadConfig(flags)
+		assert.Equal(nil, err)
+		assert.Equal("srvd-key.", config.TSIGName)
+		assert.Equal("hmac-sha256.", config.TSIGAlgorithm)
+		assert.Equal("c2VjcmV0", config.TSIGSecret)
+	})
+}
+
+func TestLoadConfigWithUnsupportedTSIGAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+tsig_name = "srvd-key"
+tsig_algorithm = "hmac-md4"
+tsig_secret = "c2VjcmV0"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("Unsupported tsig_algorithm: hmac-md4.", err.Error())
+	})
+}
+
+func TestLoadConfigWithInvalidDNSSEC(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+dnssec = "on"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("dnssec must be 'off', 'require_ad' or 'validate'", err.Error())
+	})
+}
+
+func TestLoadConfigWithDNSSECValidateWithoutTrustAnchors(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+dnssec = "validate"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("trust_anchors or trust_anchor_file is required when dnssec is 'validate'", err.Error())
+	})
+}
+
+func TestLoadConfigWithDomainResolvers(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com", "_svc._tcp.service.consul"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+nameservers = ["10.0.0.2:53", "10.0.0.3"]
+
+[domain."_svc._tcp.service.consul"]
+nameservers = ["127.0.0.1:8600"]
+net = "tcp"
+edns0_size = 1232
+timeout = 1
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		config, err := LoadConfig(flags)
+		assert.Equal(nil, err)
+		assert.Equal([]string{"10.0.0.2:53", "10.0.0.3"}, config.Nameservers)
+
+		assert.Equal(map[string]DomainConfig{
+			"_svc._tcp.service.consul": DomainConfig{Nameservers: []string{"127.0.0.1:8600"}, Net: "tcp", Edns0Size: 1232, Timeout: 1},
+		}, config.Domain)
+	})
+}
+
+func TestLoadConfigWithUnknownDomainResolver(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+
+[domain."_svc._tcp.service.consul"]
+nameservers = ["127.0.0.1:8600"]
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal(`domain."_svc._tcp.service.consul" is not in domains`, err.Error())
+	})
+}
+
+func TestLoadConfigWithInvalidResolverMode(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+resolver_mode = "any"
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("resolver_mode must be 'first', 'all_agree', 'majority' or 'union'", err.Error())
+	})
+}
+
+func TestLoadConfigWithInvalidMaxTTL(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+min_ttl = 60
+max_ttl = 30
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("max_ttl must be '>= min_ttl'", err.Error())
+	})
+}
+
+func TestLoadConfigWithInvalidPrefetch(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+prefetch = 1.5
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("prefetch must be '>= 0' && '< 1'", err.Error())
+	})
+}
+
+func TestLoadConfigWithInvalidSplay(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+splay = -1
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		_, err := LoadConfig(flags)
+		assert.Equal("backoff_max and splay must be '>= 0'", err.Error())
+	})
+}
+
+func TestLoadConfigWithNotify(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+
+[notify]
+listen = "127.0.0.1:5353"
+zones = ["Example.com"]
+tsig_name = "srvd-key"
+tsig_secret = "c3J2ZC10ZXN0LXNlY3JldA=="
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		config, err := LoadConfig(flags)
+		assert.Equal(nil, err)
+
+		assert.Equal(NotifyConfig{
+			Listen:        "127.0.0.1:5353",
+			Zones:         []string{"example.com."},
+			TSIGName:      "srvd-key.",
+			TSIGAlgorithm: dns.HmacSHA256,
+			TSIGSecret:    "c3J2ZC10ZXN0LXNlY3JldA==",
+		}, config.Notify)
+	})
+}
+
+func TestLoadConfigWithInvalidNotify(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	for notify, message := range map[string]string{
+		`listen = "5353"`:  "notify.listen must be 'host:port': address 5353: missing port in address",
+		`listen = ":5353"`: "notify.zones is required",
+		`listen = ":5353"
+zones = ["example.com"]
+tsig_name = "srvd-key"`: "notify.tsig_secret or notify.tsig_secret_file is required",
+	} {
+		conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+
+[notify]
+` + notify
+
+		testutils.TempFile(conf, func(f *os.File) {
+			flags.Config = f.Name()
+			_, err := LoadConfig(flags)
+			assert.Equal(message, err.Error())
+		})
+	}
+}
+
+func TestLoadConfigWithTransfer(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+
+[transfer]
+zone = "Svc.example.com"
+primary = "10.0.0.1"
+include = ["_*._tcp.*"]
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		config, err := LoadConfig(flags)
+		assert.Equal(nil, err)
+
+		assert.Equal(TransferConfig{
+			Zone:    "svc.example.com.",
+			Primary: "10.0.0.1:53",
+			Include: []string{"_*._tcp.*"},
+		}, config.Transfer)
+	})
+}
+
+func TestLoadConfigWithInvalidTransfer(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	for transfer, message := range map[string]string{
+		`primary = "10.0.0.1"`:     "transfer.zone is required",
+		`zone = "svc.example.com"`: "transfer.primary is required",
+		`zone = "svc.example.com"
+primary = "10.0.0.1"
+exclude = ["[_mysql"]`: "Invalid transfer pattern: [_mysql",
+	} {
+		conf := `
+src = "src"
+dest = "dest"
+domains = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+
+[transfer]
+` + transfer
+
+		testutils.TempFile(conf, func(f *os.File) {
+			flags.Config = f.Name()
+			_, err := LoadConfig(flags)
+			assert.Equal(message, err.Error())
+		})
+	}
+}
+
+func TestLoadConfigWithBrowse(t *testing.T) {
+	assert := assert.New(t)
+	flags := &Flags{}
+
+	conf := `
+src = "src"
+dest = "dest"
+browse = ["_http._tcp.example.com"]
+reload_cmd = "service reload nginx"
+interval = 1
+timeout = 2
+`
+
+	testutils.TempFile(conf, func(f *os.File) {
+		flags.Config = f.Name()
+		config, err := LoadConfig(flags)
+		assert.Equal(nil, err)
+		assert.Equal([]string{"_http._tcp.example.com"}, config.Browse)
+		assert.Equal(0, len(config.Domains))
+	})
+}
@@ -1,48 +1,272 @@
 package main
 
 import (
+	"crypto/tls"
+	"fmt"
 	"log"
 	"net"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
 )
 
+const (
+	// DomainReasonOK means that the SRV records were found.
+	DomainReasonOK = "ok"
+	// DomainReasonNotFound means that the SRV records were not found.
+	DomainReasonNotFound = "not_found"
+	// DomainReasonUnavailable means that the service is decidedly not available (RFC 2782).
+	DomainReasonUnavailable = "unavailable"
+	// DomainReasonError means that the answer could not be used.
+	DomainReasonError = "error"
+	// MaxCNAMEChain is the maximum length of CNAME chain to follow.
+	MaxCNAMEChain = 8
+)
+
 // SRVCache struct has SRV record and expiration date.
 type SRVCache struct {
-	SRVs      []*dns.SRV
-	ExpiredAt time.Time
+	SRVs        []*dns.SRV
+	ExpiredAt   time.Time
+	RefreshAt   time.Time
+	TTL         time.Duration
+	Unavailable bool
+	Negative    bool
+	Prefetched  bool
+	prefetching bool
+}
+
+// DomainStatus struct has the lookup result of the domain.
+type DomainStatus struct {
+	Reason              string
+	Error               string     `json:",omitempty"`
+	TCPFallback         bool       `json:",omitempty"`
+	DNSSEC              string     `json:",omitempty"`
+	FQDN                string     `json:",omitempty"`
+	Warning             string     `json:",omitempty"`
+	ConsecutiveFailures uint64     `json:",omitempty"`
+	RetryAt             *time.Time `json:",omitempty"`
 }
 
 // DNSClient struct has DNS query information.
 type DNSClient struct {
-	ClientConfig *dns.ClientConfig
-	Client       *dns.Client
-	Messages     map[string]*dns.Msg
-	Cache        map[string]*SRVCache
+	ClientConfig   *dns.ClientConfig
+	Port           string
+	Client         *dns.Client
+	TCPClient      *dns.Client
+	DoH            *DoHClient
+	TSIGName       string
+	TSIGAlgo       string
+	DNSSEC         string
+	TrustAnchors   map[string][]dns.RR
+	Attempts       int
+	Rotate         bool
+	ResolverMode   string
+	MinTTL         int
+	MaxTTL         int
+	TTLRefresh     bool
+	TTLJitter      time.Duration
+	Prefetch       float64
+	BackoffBase    time.Duration
+	BackoffMax     time.Duration
+	Messages       map[string]*dns.Msg
+	Names          map[string][]string
+	Cache          map[string]*SRVCache
+	Statuses       map[string]*DomainStatus
+	Services       map[string]*dns.Msg
+	BrowseStatuses map[string]*DomainStatus
+	BrowseCaches   map[string]*BrowseCache
+	BrowseBackoffs map[string]*DomainBackoff
+	Metrics        Metrics
+	Health         map[string]*ServerHealth
+	Backoffs       map[string]*DomainBackoff
+	Nameservers    []string
+	Resolvers      map[string]*DomainResolver
+	tsigSecret     map[string]string
+	tlsConfig      *tls.Config
+	rotation       int
+	mutex          sync.Mutex
+	prefetches     sync.WaitGroup
 }
 
 // NewDNSClient creates DNSClient struct.
 func NewDNSClient(config *Config) (dnsCli *DNSClient, err error) {
 	dnsCli = &DNSClient{
-		Client: &dns.Client{
-			Net: config.Net,
-		},
-		Cache: map[string]*SRVCache{},
+		Cache:          map[string]*SRVCache{},
+		Statuses:       map[string]*DomainStatus{},
+		BrowseStatuses: map[string]*DomainStatus{},
+		BrowseCaches:   map[string]*BrowseCache{},
+		BrowseBackoffs: map[string]*DomainBackoff{},
+		DNSSEC:         config.DNSSEC,
+		Attempts:       1,
+		ResolverMode:   config.ResolverMode,
+		MinTTL:         config.MinTTL,
+		MaxTTL:         config.MaxTTL,
+		TTLRefresh:     config.TTLRefresh,
+		TTLJitter:      time.Duration(config.TTLJitter) * time.Second,
+		Prefetch:       config.Prefetch,
+		Resolvers:      map[string]*DomainResolver{},
+		Health:         map[string]*ServerHealth{},
+		Backoffs:       map[string]*DomainBackoff{},
+	}
+
+	if !config.DisableBackoff {
+		dnsCli.BackoffBase = time.Duration(config.Interval) * time.Second
+		dnsCli.BackoffMax = time.Duration(config.BackoffMax) * time.Second
+	}
+
+	if dnsCli.ResolverMode == "" {
+		dnsCli.ResolverMode = ResolverModeFirst
+	}
+
+	if config.DNSSEC == DNSSECValidate {
+		dnsCli.TrustAnchors, err = parseTrustAnchors(config.TrustAnchors)
+
+		if err != nil {
+			return
+		}
+	}
+
+	if config.TSIGName != "" {
+		dnsCli.TSIGName = config.TSIGName
+		dnsCli.TSIGAlgo = config.TSIGAlgorithm
+		dnsCli.tsigSecret = map[string]string{config.TSIGName: config.TSIGSecret}
 	}
 
+	dnsCli.Client, dnsCli.TCPClient = dnsCli.newClients(config.Net)
 	dnsCli.Messages = make(map[string]*dns.Msg, len(config.Domains))
+	dnsCli.Names = make(map[string][]string, len(config.Domains))
 
 	for _, domain := range config.Domains {
+		edns0Size := config.Edns0Size
+
+		if domainConfig, ok := config.Domain[domain]; ok && domainConfig.Edns0Size > 0 {
+			edns0Size = domainConfig.Edns0Size
+		}
+
 		msg := &dns.Msg{}
 		msg.SetQuestion(dns.Fqdn(domain), dns.TypeSRV)
 		msg.RecursionDesired = true
-		msg.SetEdns0(config.Edns0Size, true)
+		msg.SetEdns0(edns0Size, true)
+		// Validate locally even if the resolver considers the answer bogus
+		msg.CheckingDisabled = config.DNSSEC == DNSSECValidate
 		dnsCli.Messages[domain] = msg
+		dnsCli.Names[domain] = []string{msg.Question[0].Name}
+	}
+
+	dnsCli.Services = make(map[string]*dns.Msg, len(config.Browse))
+
+	for _, service := range config.Browse {
+		msg := &dns.Msg{}
+		msg.SetQuestion(dns.Fqdn(service), dns.TypePTR)
+		msg.RecursionDesired = true
+		msg.SetEdns0(config.Edns0Size, true)
+		msg.CheckingDisabled = config.DNSSEC == DNSSECValidate
+		dnsCli.Services[service] = msg
+	}
+
+	if config.DNSAttempts > 0 {
+		dnsCli.Attempts = config.DNSAttempts
+	}
+
+	if config.DoHURL != "" {
+		dnsCli.DoH, err = NewDoHClient(config)
+
+		if err != nil {
+			err = fmt.Errorf("DoH client creation failed: %s", err)
+			return
+		}
+
+		for domain, domainConfig := range config.Domain {
+			if domainConfig.Timeout > 0 {
+				dnsCli.Resolvers[domain] = &DomainResolver{DoH: dnsCli.DoH.withTimeout(time.Duration(domainConfig.Timeout) * time.Second)}
+			}
+		}
+
+		return
+	}
+
+	dnsCli.ClientConfig, err = loadClientConfig(config)
+
+	if err != nil {
+		return
+	}
+
+	dnsCli.Port = dnsCli.ClientConfig.Port
+	err = dnsCli.applyResolvOptions(config)
+
+	if err != nil {
+		return
+	}
+
+	// Expand the names with the search list like the system resolver
+	for _, domain := range config.Domains {
+		dnsCli.Names[domain] = dnsCli.ClientConfig.NameList(domain)
+	}
+
+	if usesTLS(config) {
+		dnsCli.tlsConfig, err = NewTLSConfig(&config.TLS)
+
+		if err != nil {
+			err = fmt.Errorf("TLS configuration failed: %s", err)
+			return
+		}
+	}
+
+	if config.Net == "tcp-tls" {
+		dnsCli.Client.TLSConfig = dnsCli.tlsConfig
+		dnsCli.Port = DefaultTLSPort
+	}
+
+	dnsCli.Nameservers = normalizeNameservers(config.Nameservers, dnsCli.Port)
+
+	for domain, domainConfig := range config.Domain {
+		dnsCli.Resolvers[domain] = dnsCli.newDomainResolver(config, &domainConfig)
+	}
+
+	return
+}
+
+// applyResolvOptions applies the timeout, attempts and rotate options of resolv.conf.
+// The options are applied only if they are specified, and srvd's own settings take precedence over them.
+func (dnsCli *DNSClient) applyResolvOptions(config *Config) (err error) {
+	options, err := loadResolvOptions(config.ResolvConf)
+
+	// resolv.conf is optional if nameservers is specified
+	if os.IsNotExist(err) && len(config.Nameservers) > 0 {
+		options = map[string]string{}
+		err = nil
+	} else if err != nil {
+		return
+	}
+
+	timeout := config.DNSTimeout
+
+	if _, ok := options["timeout"]; ok && timeout == 0 {
+		timeout = dnsCli.ClientConfig.Timeout
+	}
+
+	if timeout > 0 {
+		dnsCli.Client.Timeout = time.Duration(timeout) * time.Second
+
+		if dnsCli.TCPClient != nil {
+			dnsCli.TCPClient.Timeout = dnsCli.Client.Timeout
+		}
+	}
+
+	if _, ok := options["attempts"]; ok && config.DNSAttempts == 0 {
+		dnsCli.Attempts = dnsCli.ClientConfig.Attempts
+	}
+
+	if config.DNSRotate != nil {
+		dnsCli.Rotate = *config.DNSRotate
+	} else {
+		_, dnsCli.Rotate = options["rotate"]
 	}
 
-	dnsCli.ClientConfig, err = dns.ClientConfigFromFile(config.ResolvConf)
 	return
 }
 
@@ -69,54 +293,407 @@ func sortSRVs(srvs []*dns.SRV) {
 	})
 }
 
+// extractSRVs extracts the SRV records of the question name from the answer.
+// It follows CNAME chains and ignores the records of other names or types.
+func extractSRVs(qname string, answer []dns.RR) (srvs []*dns.SRV, err error) {
+	srvs = []*dns.SRV{}
+	owners := map[string]bool{strings.ToLower(dns.Fqdn(qname)): true}
+
+	for i := 0; i < MaxCNAMEChain; i++ {
+		followed := false
+
+		for _, rr := range answer {
+			if cname, ok := rr.(*dns.CNAME); ok && owners[strings.ToLower(cname.Hdr.Name)] {
+				target := strings.ToLower(dns.Fqdn(cname.Target))
+
+				if !owners[target] {
+					owners[target] = true
+					followed = true
+				}
+			}
+		}
+
+		if !followed {
+			break
+		}
+	}
+
+	for _, rr := range answer {
+		switch a := rr.(type) {
+		case *dns.SRV:
+			if owners[strings.ToLower(a.Hdr.Name)] {
+				srvs = append(srvs, a)
+			} else {
+				log.Printf("WARNING: Ignore SRV record of the unexpected owner: %s", a.Hdr.Name)
+			}
+		case *dns.CNAME:
+			// followed above
+		case *dns.RRSIG:
+			// verified by checkDNSSEC
+		default:
+			log.Printf("WARNING: Ignore the unrelated record: %s", strings.Replace(rr.String(), "\t", " ", -1))
+		}
+	}
+
+	if len(srvs) == 0 && len(answer) > 0 {
+		err = fmt.Errorf("No usable SRV record in the answer")
+	}
+
+	return
+}
+
+// isUnavailable returns whether the SRV records mean that the service is decidedly not available.
+// RFC 2782: A Target of "." means that the service is decidedly not available at this domain.
+func isUnavailable(srvs []*dns.SRV) bool {
+	return len(srvs) == 1 && srvs[0].Target == "."
+}
+
 // Dig queries the SRV record.
 func (dnsCli *DNSClient) Dig() (srvsByDomain map[string][]*dns.SRV) {
 	srvsByDomain = make(map[string][]*dns.SRV, len(dnsCli.Messages))
 
 	for domain, msg := range dnsCli.Messages {
-		if cachedEntry, ok := dnsCli.Cache[domain]; ok {
-			if cachedEntry.ExpiredAt.Before(time.Now()) {
-				delete(dnsCli.Cache, domain)
-			} else {
-				srvsByDomain[domain] = cachedEntry.SRVs
-				continue
+		if srvs, ok := dnsCli.cached(domain, msg); ok {
+			srvsByDomain[domain] = srvs
+			continue
+		}
+
+		// The domain failed recently is not queried until the backoff expires
+		if dnsCli.backedOff(dnsCli.Backoffs, domain) {
+			srvsByDomain[domain] = []*dns.SRV{}
+			continue
+		}
+
+		srvsByDomain[domain] = dnsCli.lookup(domain, msg, false)
+	}
+
+	return
+}
+
+// lookup queries the SRV record of the domain, and caches the answer and the status.
+// The answer refreshed by prefetch is marked to count the prefetch hit.
+func (dnsCli *DNSClient) lookup(domain string, msg *dns.Msg, prefetch bool) (srvs []*dns.SRV) {
+	status := &DomainStatus{Reason: DomainReasonNotFound}
+	// The negative answer is not cached if any name failed or it cannot be validated
+	negTTL := int64(-1)
+	cacheable := dnsCli.DNSSEC != DNSSECValidate
+	var entry *SRVCache
+	var ttl time.Duration
+
+	for _, name := range dnsCli.Names[domain] {
+		q := msg
+
+		if name != msg.Question[0].Name {
+			q = msg.Copy()
+			q.Question[0].Name = name
+		}
+
+		var nameNegTTL int64
+		var err error
+
+		srvs, nameNegTTL, err = dnsCli.resolveSRV(domain, q, status)
+
+		if err != nil {
+			status.FQDN = name
+			status.Reason = DomainReasonError
+			status.Error = err.Error()
+			break
+		}
+
+		// Try the next name of the search list
+		if len(srvs) == 0 {
+			if nameNegTTL < 0 {
+				cacheable = false
+			} else if negTTL < 0 || nameNegTTL < negTTL {
+				negTTL = nameNegTTL
 			}
+
+			continue
+		}
+
+		status.FQDN = name
+		sortSRVs(srvs)
+		unavailable := isUnavailable(srvs)
+		ttl = dnsCli.clampTTL(minTTL(srvs))
+
+		if unavailable {
+			log.Printf("WARNING: %s service is decidedly not available", domain)
+			srvs = []*dns.SRV{}
+			status.Reason = DomainReasonUnavailable
+		} else {
+			status.Reason = DomainReasonOK
+		}
+
+		status.Error = ""
+		entry = &SRVCache{SRVs: srvs, Unavailable: unavailable, Prefetched: prefetch}
+		break
+	}
+
+	if entry == nil {
+		srvs = []*dns.SRV{}
+
+		if status.Reason == DomainReasonNotFound && cacheable && negTTL >= 0 {
+			entry = &SRVCache{SRVs: srvs, Negative: true, Prefetched: prefetch}
+			ttl = dnsCli.clampTTL(uint32(negTTL))
 		}
+	}
+
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	dnsCli.recordLookup(dnsCli.Backoffs, domain, status, entry != nil)
+	dnsCli.Statuses[domain] = status
+
+	if entry != nil {
+		dnsCli.cache(domain, entry, ttl)
+	}
+
+	return
+}
+
+// resolveSRV returns the SRV records of the name according to resolver_mode.
+func (dnsCli *DNSClient) resolveSRV(domain string, msg *dns.Msg, status *DomainStatus) (srvs []*dns.SRV, negTTL int64, err error) {
+	if dnsCli.ResolverMode == ResolverModeFirst {
+		srvs, negTTL, err = dnsCli.resolve(domain, msg, status)
+	} else {
+		srvs, negTTL, err = dnsCli.resolveQuorum(domain, msg, status)
+	}
+
+	return
+}
+
+// resolve returns the SRV records of the first answer (resolver_mode = "first").
+// It returns no SRV record if the name is not found, with the TTL of the negative answer (-1 if unknown).
+func (dnsCli *DNSClient) resolve(domain string, msg *dns.Msg, status *DomainStatus) (srvs []*dns.SRV, negTTL int64, err error) {
+	negTTL = -1
+	// The search list is not continued if the resolvers do not answer
+	r, err := dnsCli.resolveMsg(domain, msg, status)
 
+	if err != nil {
+		return
+	}
+
+	if r.Rcode == dns.RcodeNameError || len(r.Answer) == 0 {
+		negTTL = negativeTTL(r)
+		return
+	}
+
+	srvs, err = extractSRVs(msg.Question[0].Name, r.Answer)
+	return
+}
+
+// resolveMsg returns the first answer of the message.
+// The NOERROR answer is checked according to the dnssec option.
+func (dnsCli *DNSClient) resolveMsg(domain string, msg *dns.Msg, status *DomainStatus) (r *dns.Msg, err error) {
+	r, server, err := dnsCli.query(domain, msg, status)
+
+	if err != nil {
+		return
+	}
+
+	if r.Rcode == dns.RcodeSuccess {
+		err = dnsCli.checkDNSSEC(domain, msg, r, server, status)
+	}
+
+	return
+}
+
+// query sends the message to the resolvers following the attempts and rotate options.
+// It returns the first response with NOERROR or NXDOMAIN, and the address of the resolver.
+// If no resolver answers, it returns the last error.
+// Only network errors (e.g. timeout) are retried.
+func (dnsCli *DNSClient) query(domain string, msg *dns.Msg, status *DomainStatus) (r *dns.Msg, hostPort string, err error) {
+	servers := dnsCli.servers(domain)
+
+	for attempt := 0; attempt < dnsCli.Attempts; attempt++ {
+		retry := false
+
+		for _, server := range servers {
+			res, retriable, e := dnsCli.queryServer(domain, msg, server, status)
+
+			if res != nil {
+				r = res
+				hostPort = server
+				err = nil
+				return
+			}
+
+			err = e
+			retry = retry || retriable
+		}
+
+		if !retry {
+			break
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("No resolver is available")
+	}
+
+	return
+}
+
+// queryServer sends the message to the resolver and records the health of the resolver.
+// It returns the response with NOERROR or NXDOMAIN, or the error and whether it can be retried.
+func (dnsCli *DNSClient) queryServer(domain string, msg *dns.Msg, server string, status *DomainStatus) (r *dns.Msg, retry bool, err error) {
+	started := time.Now()
+	res, err := dnsCli.exchange(domain, msg, server, status)
+
+	if err != nil {
+		log.Println("WARNING: DNS lookup failed: ", err)
+		dnsCli.recordFailure(server)
+		_, retry = err.(net.Error)
+	} else if res.Rcode != dns.RcodeSuccess && res.Rcode != dns.RcodeNameError {
+		log.Printf("WARNING: DNS Response Code is not NOERROR: RCODE=%d\n", res.Rcode)
+		dnsCli.recordFailure(server)
+		err = fmt.Errorf("The response from %s is %s", server, dns.RcodeToString[res.Rcode])
+	} else {
+		dnsCli.recordSuccess(server, time.Since(started))
+		r = res
+	}
+
+	return
+}
+
+// servers returns the addresses of the resolvers of the domain (the URL for DNS over HTTPS).
+// The deprioritized resolvers are moved to the end.
+// The nameservers of [domain."name"] take precedence over nameservers, which take precedence over resolv.conf.
+// With the rotate option, the first resolver changes for each call.
+func (dnsCli *DNSClient) servers(domain string) (servers []string) {
+	if dnsCli.DoH != nil {
+		return []string{dnsCli.DoH.URL}
+	}
+
+	nameservers := dnsCli.Nameservers
+	port := dnsCli.Port
+
+	if resolver, ok := dnsCli.Resolvers[domain]; ok {
+		nameservers = resolver.Nameservers
+		port = resolver.Port
+	}
+
+	if len(nameservers) > 0 {
+		servers = append(servers, nameservers...)
+	} else {
 		for _, server := range dnsCli.ClientConfig.Servers {
-			hostPort := net.JoinHostPort(server, dnsCli.ClientConfig.Port)
-			r, _, err := dnsCli.Client.Exchange(msg, hostPort)
+			servers = append(servers, net.JoinHostPort(server, port))
+		}
+	}
 
-			if err != nil {
-				log.Println("WARNING: DNS lookup failed: ", err)
-			} else if r.Rcode != dns.RcodeSuccess {
-				log.Printf("WARNING: DNS Response Code is not NOERROR: RCODE=%d\n", r.Rcode)
-			} else if r != nil {
-				srvs := make([]*dns.SRV, len(r.Answer))
-
-				for i, a := range r.Answer {
-					srvs[i] = a.(*dns.SRV)
-				}
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
 
-				sortSRVs(srvs)
-				srvsByDomain[domain] = srvs
+	if dnsCli.Rotate && len(servers) > 1 {
+		offset := dnsCli.rotation % len(servers)
+		dnsCli.rotation++
+		servers = append(servers[offset:], servers[:offset]...)
+	}
 
-				if len(srvs) > 0 {
-					ttl := time.Duration(srvs[0].Hdr.Ttl) * time.Second
+	servers = dnsCli.orderByHealth(servers)
+	return
+}
 
-					dnsCli.Cache[domain] = &SRVCache{
-						SRVs:      srvs,
-						ExpiredAt: time.Now().Add(ttl),
-					}
-				}
+// exchange sends the query to the server.
+// If the UDP response is truncated, it retries over TCP ("auto" and "udp"), otherwise it returns an error.
+func (dnsCli *DNSClient) exchange(domain string, msg *dns.Msg, hostPort string, status *DomainStatus) (r *dns.Msg, err error) {
+	if dnsCli.DoH != nil {
+		r, err = dnsCli.doh(domain).Exchange(msg)
+		return
+	}
+
+	if dnsCli.TSIGName != "" {
+		msg = signTSIG(msg, dnsCli.TSIGName, dnsCli.TSIGAlgo)
+		defer func() {
+			if err == nil {
+				err = verifyTSIG(r, hostPort)
+			} else if !isTSIGError(err) {
+				return
+			}
 
-				break
+			if err != nil {
+				err = fmt.Errorf("TSIG verification failed: %s", err)
+				status.Reason = DomainReasonError
+				status.Error = err.Error()
 			}
+		}()
+	}
+
+	client, tcpClient := dnsCli.clients(domain)
+	r, _, err = client.Exchange(msg, hostPort)
+
+	if err != nil || r == nil || !r.Truncated {
+		return
+	}
+
+	if tcpClient == nil {
+		err = fmt.Errorf("The response from %s is truncated", hostPort)
+		return
+	}
+
+	log.Printf("WARNING: The response from %s is truncated. Retry over TCP", hostPort)
+	dnsCli.mutex.Lock()
+	dnsCli.Metrics.TCPFallbacks++
+	dnsCli.mutex.Unlock()
+	status.TCPFallback = true
+	r, _, err = tcpClient.Exchange(msg, hostPort)
+	return
+}
+
+// checkDNSSEC checks the response according to the dnssec setting and records the result in the status.
+// In "validate" mode, the denial of existence (NSEC/NSEC3) is not verified and an empty answer is reported as insecure.
+func (dnsCli *DNSClient) checkDNSSEC(domain string, msg *dns.Msg, r *dns.Msg, hostPort string, status *DomainStatus) (err error) {
+	switch dnsCli.DNSSEC {
+	case DNSSECRequireAD:
+		if !r.AuthenticatedData {
+			status.DNSSEC = DNSSECStatusInsecure
+			err = fmt.Errorf("The response from %s does not have the AD flag", hostPort)
+			return
 		}
 
-		if _, ok := srvsByDomain[domain]; !ok {
-			srvsByDomain[domain] = []*dns.SRV{}
+		status.DNSSEC = DNSSECStatusSecure
+	case DNSSECValidate:
+		if len(r.Answer) == 0 {
+			status.DNSSEC = DNSSECStatusInsecure
+			return
 		}
+
+		validator := newDNSSECValidator(dnsCli.TrustAnchors, func(name string, qtype uint16) (*dns.Msg, error) {
+			q := msg.Copy()
+			q.Id = dns.Id()
+			q.Question[0] = dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}
+			return dnsCli.exchange(domain, q, hostPort, status)
+		})
+
+		err = validator.validate(r.Answer, msg.Question[0].Qtype)
+
+		if err != nil {
+			status.DNSSEC = DNSSECStatusBogus
+			err = fmt.Errorf("DNSSEC validation failed: %s", err)
+			return
+		}
+
+		status.DNSSEC = DNSSECStatusSecure
+	}
+
+	return
+}
+
+// IsUnavailable returns whether the last lookup of the domain answered that the service is decidedly not available.
+func (dnsCli *DNSClient) IsUnavailable(domain string) bool {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	status, ok := dnsCli.Statuses[domain]
+	return ok && status.Reason == DomainReasonUnavailable
+}
+
+// DomainStatuses returns a copy of the lookup results of the domains.
+func (dnsCli *DNSClient) DomainStatuses() (statuses map[string]DomainStatus) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	statuses = make(map[string]DomainStatus, len(dnsCli.Statuses))
+
+	for domain, status := range dnsCli.Statuses {
+		statuses[domain] = *status
 	}
 
 	return
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
 	"regexp"
 	"testing"
 	"time"
@@ -90,6 +94,7 @@ func TestDNSClientDigNotFound(t *testing.T) {
 	assert.Equal(1, len(srvsByDomain))
 	srvs := srvsByDomain["_not_exist._tcp.winebarrel.jp"]
 	assert.Equal(0, len(srvs))
+	assert.Equal(false, dnsCli.IsUnavailable("_not_exist._tcp.winebarrel.jp"))
 }
 
 func TestDNSClientSortSRVs(t *testing.T) {
@@ -129,9 +134,9 @@ func TestDNSClientDigWithTTL(t *testing.T) {
 	testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
 		return func(_ *dns.Client, _ *dns.Msg, _ string) (r *dns.Msg, _ time.Duration, _ error) {
 			answer := []dns.RR{
-				&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
-				&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
-				&dns.SRV{Priority: 10, Weight: 100, Target: "server3.example.com", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server3.example.com", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
 			}
 
 			if counter == 0 {
@@ -148,9 +153,9 @@ func TestDNSClientDigWithTTL(t *testing.T) {
 	})
 
 	expect := []*dns.SRV{
-		&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
-		&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
-		&dns.SRV{Priority: 10, Weight: 100, Target: "server3.example.com", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
+		&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+		&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+		&dns.SRV{Priority: 10, Weight: 100, Target: "server3.example.com", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
 	}
 
 	srvs1 := dnsCli.Dig()["_mysql._tcp.example.com"]
@@ -164,3 +169,359 @@ func TestDNSClientDigWithTTL(t *testing.T) {
 	assert.Equal(expect, srvs2)
 	assert.Equal(expect[1:], srvs3)
 }
+
+func TestDNSClientDigUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:    []string{"_mysql._tcp.example.com"},
+		ResolvConf: "/etc/resolv.conf",
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	counter := 0
+
+	testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
+		return func(_ *dns.Client, _ *dns.Msg, _ string) (r *dns.Msg, _ time.Duration, _ error) {
+			defer (*guard).Unpatch()
+			(*guard).Restore()
+
+			answer := []dns.RR{
+				&dns.SRV{Priority: 0, Weight: 0, Target: ".", Port: 0, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 60}},
+			}
+
+			r = &dns.Msg{Answer: answer}
+			counter++
+			return
+		}
+	})
+
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]*dns.SRV{}, srvs)
+	assert.Equal(true, dnsCli.IsUnavailable("_mysql._tcp.example.com"))
+	assert.Equal(map[string]DomainStatus{"_mysql._tcp.example.com": DomainStatus{Reason: DomainReasonUnavailable, FQDN: "_mysql._tcp.example.com."}}, dnsCli.DomainStatuses())
+
+	// cached
+	srvs = dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(1, counter)
+	assert.Equal([]*dns.SRV{}, srvs)
+	assert.Equal(true, dnsCli.IsUnavailable("_mysql._tcp.example.com"))
+}
+
+func TestDNSClientDigWithCNAME(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:    []string{"_mysql._tcp.example.com"},
+		ResolvConf: "/etc/resolv.conf",
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+
+	testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
+		return func(_ *dns.Client, _ *dns.Msg, _ string) (r *dns.Msg, _ time.Duration, _ error) {
+			defer (*guard).Unpatch()
+			(*guard).Restore()
+
+			answer := []dns.RR{
+				&dns.CNAME{Target: "_mysql._tcp.db.example.com.", Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com."}},
+				&dns.CNAME{Target: "_mysql._tcp.db2.example.com.", Hdr: dns.RR_Header{Name: "_mysql._tcp.DB.example.com."}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.db2.example.com.", Ttl: 3}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_other._tcp.example.com.", Ttl: 3}},
+				&dns.A{A: net.IPv4(192, 168, 0, 1), Hdr: dns.RR_Header{Name: "server1.example.com.", Rrtype: dns.TypeA}},
+			}
+
+			r = &dns.Msg{Answer: answer}
+			return
+		}
+	})
+
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(1, len(srvs))
+	assert.Equal("server1.example.com.", srvs[0].Target)
+	assert.Equal(map[string]DomainStatus{"_mysql._tcp.example.com": DomainStatus{Reason: DomainReasonOK, FQDN: "_mysql._tcp.example.com."}}, dnsCli.DomainStatuses())
+}
+
+func TestDNSClientDigWithoutUsableSRV(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:    []string{"_mysql._tcp.example.com"},
+		ResolvConf: "/etc/resolv.conf",
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+
+	testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
+		return func(_ *dns.Client, _ *dns.Msg, _ string) (r *dns.Msg, _ time.Duration, _ error) {
+			defer (*guard).Unpatch()
+			(*guard).Restore()
+
+			answer := []dns.RR{
+				&dns.A{A: net.IPv4(192, 168, 0, 1), Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Rrtype: dns.TypeA}},
+			}
+
+			r = &dns.Msg{Answer: answer}
+			return
+		}
+	})
+
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]*dns.SRV{}, srvs)
+
+	assert.Equal(map[string]DomainStatus{
+		"_mysql._tcp.example.com": DomainStatus{Reason: DomainReasonError, Error: "No usable SRV record in the answer", FQDN: "_mysql._tcp.example.com."},
+	}, dnsCli.DomainStatuses())
+}
+
+func TestDNSClientDigTruncated(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:    []string{"_mysql._tcp.example.com"},
+		ResolvConf: "/etc/resolv.conf",
+		Net:        "auto",
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	nets := []string{}
+
+	testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
+		return func(c *dns.Client, _ *dns.Msg, _ string) (r *dns.Msg, _ time.Duration, _ error) {
+			nets = append(nets, c.Net)
+
+			answer := []dns.RR{
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+			}
+
+			if c.Net == "udp" {
+				r = &dns.Msg{Answer: answer[:1]}
+				r.Truncated = true
+			} else {
+				defer (*guard).Unpatch()
+				(*guard).Restore()
+				r = &dns.Msg{Answer: answer}
+			}
+
+			return
+		}
+	})
+
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]string{"udp", "tcp"}, nets)
+	assert.Equal(2, len(srvs))
+	assert.Equal(uint64(1), dnsCli.Metrics.TCPFallbacks)
+	assert.Equal(map[string]DomainStatus{"_mysql._tcp.example.com": DomainStatus{Reason: DomainReasonOK, TCPFallback: true, FQDN: "_mysql._tcp.example.com."}}, dnsCli.DomainStatuses())
+}
+
+func TestDNSClientDigTruncatedWithUDP(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:    []string{"_mysql._tcp.example.com"},
+		ResolvConf: "/etc/resolv.conf",
+		Net:        "udp",
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	nets := []string{}
+
+	testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
+		return func(c *dns.Client, _ *dns.Msg, _ string) (r *dns.Msg, _ time.Duration, _ error) {
+			nets = append(nets, c.Net)
+
+			answer := []dns.RR{
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Ttl: 3}},
+			}
+
+			if c.Net == "udp" {
+				r = &dns.Msg{Answer: answer[:1]}
+				r.Truncated = true
+			} else {
+				defer (*guard).Unpatch()
+				(*guard).Restore()
+				r = &dns.Msg{Answer: answer}
+			}
+
+			return
+		}
+	})
+
+	// The truncated response is retried over TCP as "auto"
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]string{"udp", "tcp"}, nets)
+	assert.Equal(2, len(srvs))
+	assert.Equal(uint64(1), dnsCli.Metrics.TCPFallbacks)
+}
+
+func srvHandler(ttl uint32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(req)
+
+		m.Answer = []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl}},
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestDNSClientDigWithTLS(t *testing.T) {
+	assert := assert.New(t)
+	certPEM, keyPEM := testutils.SelfSignedCert()
+	cert, _ := tls.X509KeyPair(certPEM, keyPEM)
+	addr, shutdown := testutils.StartDNSServer("tcp-tls", srvHandler(3), &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer shutdown()
+	host, port, _ := net.SplitHostPort(addr)
+
+	testutils.TempFile(string(certPEM), func(caFile *os.File) {
+		config := &Config{
+			Domains:    []string{"_mysql._tcp.example.com"},
+			ResolvConf: "/etc/resolv.conf",
+			Net:        "tcp-tls",
+			TLS:        TLSConfig{ServerName: "localhost", CAFile: caFile.Name()},
+		}
+
+		dnsCli, err := NewDNSClient(config)
+		assert.Equal(nil, err)
+		assert.Equal("853", dnsCli.Port)
+		dnsCli.ClientConfig.Servers = []string{host}
+		dnsCli.Port = port
+
+		srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+		assert.Equal(1, len(srvs))
+		assert.Equal("server1.example.com.", srvs[0].Target)
+	})
+}
+
+func TestDNSClientDigWithTLSUnknownCA(t *testing.T) {
+	assert := assert.New(t)
+	certPEM, keyPEM := testutils.SelfSignedCert()
+	cert, _ := tls.X509KeyPair(certPEM, keyPEM)
+	addr, shutdown := testutils.StartDNSServer("tcp-tls", srvHandler(3), &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer shutdown()
+	host, port, _ := net.SplitHostPort(addr)
+
+	config := &Config{
+		Domains:    []string{"_mysql._tcp.example.com"},
+		ResolvConf: "/etc/resolv.conf",
+		Net:        "tcp-tls",
+		TLS:        TLSConfig{ServerName: "localhost"},
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	dnsCli.ClientConfig.Servers = []string{host}
+	dnsCli.Port = port
+
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+}
+
+func TestDNSClientDigWithSearchList(t *testing.T) {
+	assert := assert.New(t)
+	names := []string{}
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		names = append(names, req.Question[0].Name)
+
+		if req.Question[0].Name == "_http._tcp.api.corp.example.com." {
+			srvHandler(3)(w, req)
+			return
+		}
+
+		m := &dns.Msg{}
+		m.SetRcode(req, dns.RcodeNameError)
+		w.WriteMsg(m)
+	}), nil)
+
+	defer shutdown()
+	host, port, _ := net.SplitHostPort(addr)
+
+	testutils.TempFile("nameserver "+host+"\nsearch dev.example.com corp.example.com\noptions ndots:3\n", func(f *os.File) {
+		config := &Config{
+			Domains:    []string{"_http._tcp.api"},
+			ResolvConf: f.Name(),
+			Net:        "tcp",
+		}
+
+		dnsCli, _ := NewDNSClient(config)
+		dnsCli.Port = port
+		srvs := dnsCli.Dig()["_http._tcp.api"]
+		assert.Equal(1, len(srvs))
+		assert.Equal([]string{"_http._tcp.api.dev.example.com.", "_http._tcp.api.corp.example.com."}, names)
+
+		assert.Equal(map[string]DomainStatus{
+			"_http._tcp.api": DomainStatus{Reason: DomainReasonOK, FQDN: "_http._tcp.api.corp.example.com."},
+		}, dnsCli.DomainStatuses())
+	})
+}
+
+func TestDNSClientDigWithUnreachableServer(t *testing.T) {
+	assert := assert.New(t)
+
+	// Connection to the closed port is refused
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	testutils.TempFile("nameserver 127.0.0.1\nsearch dev.example.com corp.example.com\noptions ndots:3\n", func(f *os.File) {
+		config := &Config{
+			Domains:    []string{"_http._tcp.api"},
+			ResolvConf: f.Name(),
+			Net:        "tcp",
+		}
+
+		dnsCli, _ := NewDNSClient(config)
+		dnsCli.Port = port
+		srvs := dnsCli.Dig()["_http._tcp.api"]
+		assert.Equal(0, len(srvs))
+
+		// The search list is not continued
+		status := dnsCli.DomainStatuses()["_http._tcp.api"]
+		assert.Equal(DomainReasonError, status.Reason)
+		assert.Equal("_http._tcp.api.dev.example.com.", status.FQDN)
+		assert.Contains(status.Error, "connection refused")
+	})
+}
+
+func TestDNSClientDigWithAttempts(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("nameserver 127.0.0.1\nnameserver 127.0.0.2\noptions attempts:3 timeout:1 rotate\n", func(f *os.File) {
+		config := &Config{
+			Domains:    []string{"_mysql._tcp.example.com"},
+			ResolvConf: f.Name(),
+			Net:        "tcp",
+		}
+
+		dnsCli, _ := NewDNSClient(config)
+		assert.Equal(3, dnsCli.Attempts)
+		assert.Equal(true, dnsCli.Rotate)
+		assert.Equal(time.Second, dnsCli.Client.Timeout)
+		servers := []string{}
+		var patchGuard **monkey.PatchGuard
+		defer func() { (*patchGuard).Unpatch() }()
+
+		testutils.PatchMethod(dnsCli.Client, "Exchange", func(guard **monkey.PatchGuard) interface{} {
+			patchGuard = guard
+
+			return func(_ *dns.Client, _ *dns.Msg, hostPort string) (r *dns.Msg, _ time.Duration, err error) {
+				servers = append(servers, hostPort)
+				err = &net.OpError{Op: "read", Net: "tcp", Err: errors.New("i/o timeout")}
+				return
+			}
+		})
+
+		srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+		assert.Equal(0, len(srvs))
+		assert.Equal([]string{"127.0.0.1:53", "127.0.0.2:53", "127.0.0.1:53", "127.0.0.2:53", "127.0.0.1:53", "127.0.0.2:53"}, servers)
+
+		// The first resolver is rotated
+		servers = []string{}
+		dnsCli.Dig()
+		assert.Equal("127.0.0.2:53", servers[0])
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DNSSECOff does not check DNSSEC.
+	DNSSECOff = "off"
+	// DNSSECRequireAD rejects the answers without the AD flag from the (trusted) resolver.
+	DNSSECRequireAD = "require_ad"
+	// DNSSECValidate verifies the RRSIG chains of the answers against the trust anchors.
+	DNSSECValidate = "validate"
+	// DNSSECStatusSecure means that the answer was authenticated.
+	DNSSECStatusSecure = "secure"
+	// DNSSECStatusInsecure means that the answer was not authenticated.
+	DNSSECStatusInsecure = "insecure"
+	// DNSSECStatusBogus means that the validation of the answer failed.
+	DNSSECStatusBogus = "bogus"
+	// MaxDNSSECChain is the maximum number of zones to follow up to the trust anchor.
+	MaxDNSSECChain = 8
+)
+
+// LoadTrustAnchorFile loads the DS or DNSKEY records (one per line) from the file.
+func LoadTrustAnchorFile(path string) (anchors []string, err error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		anchors = append(anchors, line)
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// parseTrustAnchors parses the DS or DNSKEY records and groups them by zone.
+func parseTrustAnchors(anchors []string) (anchorsByZone map[string][]dns.RR, err error) {
+	anchorsByZone = map[string][]dns.RR{}
+
+	for _, anchor := range anchors {
+		rr, e := dns.NewRR(anchor)
+
+		if e != nil {
+			err = fmt.Errorf("Invalid trust anchor: %s", e)
+			return
+		}
+
+		switch rr.(type) {
+		case *dns.DS, *dns.DNSKEY:
+			zone := strings.ToLower(rr.Header().Name)
+			anchorsByZone[zone] = append(anchorsByZone[zone], rr)
+		default:
+			err = fmt.Errorf("Trust anchor must be DS or DNSKEY record: %s", anchor)
+			return
+		}
+	}
+
+	return
+}
+
+// dnssecValidator verifies RRSIG chains up to the trust anchors.
+type dnssecValidator struct {
+	anchors map[string][]dns.RR
+	query   func(name string, qtype uint16) (*dns.Msg, error)
+	keys    map[string][]*dns.DNSKEY
+}
+
+func newDNSSECValidator(anchors map[string][]dns.RR, query func(name string, qtype uint16) (*dns.Msg, error)) *dnssecValidator {
+	return &dnssecValidator{
+		anchors: anchors,
+		query:   query,
+		keys:    map[string][]*dns.DNSKEY{},
+	}
+}
+
+// splitRRsets groups the records of the given type by owner and collects the RRSIGs covering them.
+func splitRRsets(rrs []dns.RR, types ...uint16) (rrsets map[string][]dns.RR, sigs map[string][]*dns.RRSIG) {
+	rrsets = map[string][]dns.RR{}
+	sigs = map[string][]*dns.RRSIG{}
+	wanted := map[uint16]bool{}
+
+	for _, t := range types {
+		wanted[t] = true
+	}
+
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if wanted[sig.TypeCovered] {
+				key := strings.ToLower(sig.Hdr.Name) + "/" + dns.TypeToString[sig.TypeCovered]
+				sigs[key] = append(sigs[key], sig)
+			}
+		} else if wanted[rr.Header().Rrtype] {
+			key := strings.ToLower(rr.Header().Name) + "/" + dns.TypeToString[rr.Header().Rrtype]
+			rrsets[key] = append(rrsets[key], rr)
+		}
+	}
+
+	return
+}
+
+// validate verifies the records of the question type (e.g. SRV, PTR or TXT) and the CNAME records of the answer.
+// Other records are ignored by the callers and are not verified.
+func (v *dnssecValidator) validate(answer []dns.RR, qtype uint16) (err error) {
+	rrsets, sigs := splitRRsets(answer, qtype, dns.TypeCNAME)
+
+	for key, rrset := range rrsets {
+		err = v.verifyRRset(key, rrset, sigs[key], 0)
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// verifyRRset verifies the RRset with one of its RRSIGs.
+func (v *dnssecValidator) verifyRRset(key string, rrset []dns.RR, sigs []*dns.RRSIG, depth int) (err error) {
+	if len(sigs) == 0 {
+		err = fmt.Errorf("%s is not signed", key)
+		return
+	}
+
+	now := time.Now()
+
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			err = fmt.Errorf("RRSIG of %s is expired or not yet valid", key)
+			continue
+		}
+
+		if !dns.IsSubDomain(sig.SignerName, rrset[0].Header().Name) {
+			err = fmt.Errorf("RRSIG of %s is signed by the unrelated zone: %s", key, sig.SignerName)
+			continue
+		}
+
+		keys, e := v.zoneKeys(sig.SignerName, depth)
+
+		if e != nil {
+			err = e
+			continue
+		}
+
+		for _, k := range keys {
+			if sig.Verify(k, rrset) == nil {
+				err = nil
+				return
+			}
+		}
+
+		err = fmt.Errorf("RRSIG of %s does not match the DNSKEY of %s", key, sig.SignerName)
+	}
+
+	return
+}
+
+// zoneKeys returns the DNSKEYs of the zone authenticated by the trust anchor or the DS records of the parent zone.
+func (v *dnssecValidator) zoneKeys(zone string, depth int) (keys []*dns.DNSKEY, err error) {
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	if keys, ok := v.keys[zone]; ok {
+		return keys, nil
+	}
+
+	if depth >= MaxDNSSECChain {
+		err = fmt.Errorf("The chain of trust of %s is too long", zone)
+		return
+	}
+
+	r, err := v.query(zone, dns.TypeDNSKEY)
+
+	if err != nil {
+		err = fmt.Errorf("DNSKEY lookup of %s failed: %s", zone, err)
+		return
+	}
+
+	dnskeyKey := zone + "/DNSKEY"
+	rrsets, sigs := splitRRsets(r.Answer, dns.TypeDNSKEY)
+	dnskeys := rrsets[dnskeyKey]
+
+	if len(dnskeys) == 0 {
+		err = fmt.Errorf("No DNSKEY record of %s", zone)
+		return
+	}
+
+	anchors, ok := v.anchors[zone]
+
+	if !ok {
+		anchors, err = v.delegationSigners(zone, depth)
+
+		if err != nil {
+			return
+		}
+	}
+
+	// The DNSKEY RRset must be signed by a key authenticated by the anchors
+	for _, sig := range sigs[dnskeyKey] {
+		if !sig.ValidityPeriod(time.Now()) || strings.ToLower(sig.SignerName) != zone {
+			continue
+		}
+
+		for _, rr := range dnskeys {
+			k := rr.(*dns.DNSKEY)
+
+			if k.KeyTag() == sig.KeyTag && matchTrustAnchor(k, anchors) && sig.Verify(k, dnskeys) == nil {
+				for _, rr := range dnskeys {
+					keys = append(keys, rr.(*dns.DNSKEY))
+				}
+
+				v.keys[zone] = keys
+				return
+			}
+		}
+	}
+
+	err = fmt.Errorf("DNSKEY RRset of %s is not signed by a trusted key", zone)
+	return
+}
+
+// delegationSigners returns the DS records of the zone verified in the parent zone.
+func (v *dnssecValidator) delegationSigners(zone string, depth int) (anchors []dns.RR, err error) {
+	if zone == "." {
+		err = fmt.Errorf("No trust anchor for the root zone")
+		return
+	}
+
+	r, err := v.query(zone, dns.TypeDS)
+
+	if err != nil {
+		err = fmt.Errorf("DS lookup of %s failed: %s", zone, err)
+		return
+	}
+
+	dsKey := zone + "/DS"
+	rrsets, sigs := splitRRsets(r.Answer, dns.TypeDS)
+	anchors = rrsets[dsKey]
+
+	if len(anchors) == 0 {
+		err = fmt.Errorf("No DS record of %s (insecure delegation)", zone)
+		return
+	}
+
+	err = v.verifyRRset(dsKey, anchors, sigs[dsKey], depth+1)
+	return
+}
+
+// matchTrustAnchor returns whether the key matches one of the DS or DNSKEY records.
+func matchTrustAnchor(key *dns.DNSKEY, anchors []dns.RR) bool {
+	for _, anchor := range anchors {
+		switch a := anchor.(type) {
+		case *dns.DS:
+			ds := key.ToDS(a.DigestType)
+
+			if ds != nil && ds.KeyTag == a.KeyTag && ds.Algorithm == a.Algorithm && strings.EqualFold(ds.Digest, a.Digest) {
+				return true
+			}
+		case *dns.DNSKEY:
+			if key.Algorithm == a.Algorithm && key.Flags == a.Flags && key.PublicKey == a.PublicKey {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+type testSignedZone struct {
+	name string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestSignedZone(name string) *testSignedZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, _ := key.Generate(256)
+	return &testSignedZone{name: name, key: key, priv: priv.(crypto.Signer)}
+}
+
+func (zone *testSignedZone) sign(rrset []dns.RR) *dns.RRSIG {
+	now := time.Now()
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+		KeyTag:     zone.key.KeyTag(),
+		SignerName: zone.name,
+		Algorithm:  zone.key.Algorithm,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+	}
+
+	sig.Sign(zone.priv, rrset)
+	return sig
+}
+
+// dnssecHandler serves _mysql._tcp.example.com signed by example.com. which is delegated from com.
+func dnssecHandler(com *testSignedZone, example *testSignedZone, tamper bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(req)
+		q := req.Question[0]
+
+		switch {
+		case q.Qtype == dns.TypeSRV:
+			srv := &dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 3}}
+			sig := example.sign([]dns.RR{srv})
+
+			if tamper {
+				srv.Port = 8080
+			}
+
+			m.Answer = []dns.RR{srv, sig}
+		case q.Qtype == dns.TypeDNSKEY && q.Name == "example.com.":
+			m.Answer = []dns.RR{example.key, example.sign([]dns.RR{example.key})}
+		case q.Qtype == dns.TypeDNSKEY && q.Name == "com.":
+			m.Answer = []dns.RR{com.key, com.sign([]dns.RR{com.key})}
+		case q.Qtype == dns.TypeDS && q.Name == "example.com.":
+			ds := example.key.ToDS(dns.SHA256)
+			m.Answer = []dns.RR{ds, com.sign([]dns.RR{ds})}
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+func newDNSSECDNSClient(t *testing.T, addr string, mode string, anchors []string) *DNSClient {
+	config := &Config{
+		Domains:      []string{"_mysql._tcp.example.com"},
+		Net:          "tcp",
+		Nameservers:  []string{addr},
+		DNSSEC:       mode,
+		TrustAnchors: anchors,
+	}
+
+	dnsCli, err := NewDNSClient(config)
+	assert.Equal(t, nil, err)
+	return dnsCli
+}
+
+func TestDNSClientDigWithDNSSECValidate(t *testing.T) {
+	assert := assert.New(t)
+	com := newTestSignedZone("com.")
+	example := newTestSignedZone("example.com.")
+	addr, shutdown := testutils.StartDNSServer("tcp", dnssecHandler(com, example, false), nil)
+	defer shutdown()
+
+	// Trust anchor of the zone itself
+	dnsCli := newDNSSECDNSClient(t, addr, DNSSECValidate, []string{example.key.ToDS(dns.SHA256).String()})
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(1, len(srvs))
+	assert.Equal(DomainStatus{Reason: DomainReasonOK, DNSSEC: DNSSECStatusSecure, FQDN: "_mysql._tcp.example.com."}, *dnsCli.Statuses["_mysql._tcp.example.com"])
+
+	// Chain of trust from the parent zone
+	dnsCli = newDNSSECDNSClient(t, addr, DNSSECValidate, []string{com.key.String()})
+	srvs = dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(1, len(srvs))
+	assert.Equal(DomainStatus{Reason: DomainReasonOK, DNSSEC: DNSSECStatusSecure, FQDN: "_mysql._tcp.example.com."}, *dnsCli.Statuses["_mysql._tcp.example.com"])
+}
+
+func TestDNSClientDigWithDNSSECValidateBogus(t *testing.T) {
+	assert := assert.New(t)
+	com := newTestSignedZone("com.")
+	example := newTestSignedZone("example.com.")
+	addr, shutdown := testutils.StartDNSServer("tcp", dnssecHandler(com, example, true), nil)
+	defer shutdown()
+
+	dnsCli := newDNSSECDNSClient(t, addr, DNSSECValidate, []string{com.key.String()})
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+	status := dnsCli.Statuses["_mysql._tcp.example.com"]
+	assert.Equal(DomainReasonError, status.Reason)
+	assert.Equal(DNSSECStatusBogus, status.DNSSEC)
+	assert.Equal("DNSSEC validation failed: RRSIG of _mysql._tcp.example.com./SRV does not match the DNSKEY of example.com.", status.Error)
+}
+
+func TestDNSClientDigWithDNSSECValidateUntrustedKey(t *testing.T) {
+	assert := assert.New(t)
+	com := newTestSignedZone("com.")
+	example := newTestSignedZone("example.com.")
+	other := newTestSignedZone("example.com.")
+	addr, shutdown := testutils.StartDNSServer("tcp", dnssecHandler(com, example, false), nil)
+	defer shutdown()
+
+	dnsCli := newDNSSECDNSClient(t, addr, DNSSECValidate, []string{other.key.ToDS(dns.SHA256).String()})
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+	status := dnsCli.Statuses["_mysql._tcp.example.com"]
+	assert.Equal(DNSSECStatusBogus, status.DNSSEC)
+	assert.Equal("DNSSEC validation failed: DNSKEY RRset of example.com. is not signed by a trusted key", status.Error)
+}
+
+func TestDNSClientDigWithDNSSECRequireAD(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, ad := range []bool{true, false} {
+		authenticated := ad
+
+		addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			m := &dns.Msg{}
+			m.SetReply(req)
+			m.AuthenticatedData = authenticated
+			m.Answer = []dns.RR{
+				&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 3}},
+			}
+			w.WriteMsg(m)
+		}), nil)
+
+		dnsCli := newDNSSECDNSClient(t, addr, DNSSECRequireAD, nil)
+		srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+		status := dnsCli.Statuses["_mysql._tcp.example.com"]
+
+		if authenticated {
+			assert.Equal(1, len(srvs))
+			assert.Equal(DomainStatus{Reason: DomainReasonOK, DNSSEC: DNSSECStatusSecure, FQDN: "_mysql._tcp.example.com."}, *status)
+		} else {
+			assert.Equal(0, len(srvs))
+			assert.Equal(DomainStatus{
+				Reason: DomainReasonError,
+				Error:  "The response from " + addr + " does not have the AD flag",
+				DNSSEC: DNSSECStatusInsecure,
+				FQDN:   "_mysql._tcp.example.com.",
+			}, *status)
+		}
+
+		shutdown()
+	}
+}
+
+func TestLoadTrustAnchorFile(t *testing.T) {
+	assert := assert.New(t)
+	example := newTestSignedZone("example.com.")
+	ds := example.key.ToDS(dns.SHA256).String()
+
+	testutils.TempFile("; root zone\n\n"+ds+"\n", func(f *os.File) {
+		anchors, err := LoadTrustAnchorFile(f.Name())
+		assert.Equal(nil, err)
+		assert.Equal([]string{ds}, anchors)
+
+		anchorsByZone, err := parseTrustAnchors(anchors)
+		assert.Equal(nil, err)
+		assert.Equal(1, len(anchorsByZone["example.com."]))
+	})
+}
+
+func TestParseTrustAnchorsWithInvalidRecord(t *testing.T) {
+	assert := assert.New(t)
+	_, err := parseTrustAnchors([]string{"example.com. 3600 IN A 127.0.0.1"})
+	assert.True(strings.HasPrefix(err.Error(), "Trust anchor must be DS or DNSKEY record: "))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DoHMimeType is the media type of DNS over HTTPS (RFC 8484).
+	DoHMimeType = "application/dns-message"
+	// DefaultDoHTimeout is the timeout of DNS over HTTPS queries if dns_timeout is not specified.
+	DefaultDoHTimeout = 5 * time.Second
+)
+
+// DoHClient struct has information on DNS over HTTPS (RFC 8484) queries.
+type DoHClient struct {
+	URL        string
+	Method     string
+	HTTPClient *http.Client
+}
+
+// NewDoHClient creates DoHClient struct.
+// The http.Client is shared by the queries to reuse the connections.
+func NewDoHClient(config *Config) (doh *DoHClient, err error) {
+	tlsConfig, err := NewTLSConfig(&config.TLS)
+
+	if err != nil {
+		return
+	}
+
+	timeout := DefaultDoHTimeout
+
+	if config.DNSTimeout > 0 {
+		timeout = time.Duration(config.DNSTimeout) * time.Second
+	}
+
+	doh = &DoHClient{
+		URL:    config.DoHURL,
+		Method: config.DoHMethod,
+		HTTPClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     tlsConfig,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+
+	return
+}
+
+// withTimeout returns a copy of DoHClient with the timeout.
+// The connections are shared with the original client.
+func (doh *DoHClient) withTimeout(timeout time.Duration) *DoHClient {
+	httpClient := *doh.HTTPClient
+	httpClient.Timeout = timeout
+	return &DoHClient{URL: doh.URL, Method: doh.Method, HTTPClient: &httpClient}
+}
+
+// Exchange sends the query in wire format and returns the response.
+func (doh *DoHClient) Exchange(msg *dns.Msg) (r *dns.Msg, err error) {
+	// RFC 8484: DNS ID SHOULD be 0 for the HTTP cache
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+
+	if doh.Method == http.MethodGet {
+		req, err = http.NewRequest(http.MethodGet, doh.URL+"?dns="+base64.RawURLEncoding.EncodeToString(packed), nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, doh.URL, bytes.NewReader(packed))
+
+		if err == nil {
+			req.Header.Set("Content-Type", DoHMimeType)
+		}
+	}
+
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Accept", DoHMimeType)
+	res, err := doh.HTTPClient.Do(req)
+
+	if err != nil {
+		return
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return
+	}
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("DoH server returned HTTP %d", res.StatusCode)
+		return
+	}
+
+	if ct := res.Header.Get("Content-Type"); ct != DoHMimeType {
+		err = fmt.Errorf("Unexpected Content-Type of DoH response: %s", ct)
+		return
+	}
+
+	r = &dns.Msg{}
+	err = r.Unpack(body)
+
+	if err != nil {
+		return
+	}
+
+	r.Id = msg.Id
+	return
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDoHServer(zone map[string][]dns.RR, methods *[]string) *httptest.Server {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var packed []byte
+
+		if r.Method == http.MethodGet {
+			packed, _ = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		} else {
+			packed, _ = ioutil.ReadAll(r.Body)
+		}
+
+		*methods = append(*methods, r.Method)
+		req := &dns.Msg{}
+
+		if err := req.Unpack(packed); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m := &dns.Msg{}
+		m.SetReply(req)
+
+		if answer, ok := zone[req.Question[0].Name]; ok {
+			m.Answer = answer
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+
+		out, _ := m.Pack()
+		w.Header().Set("Content-Type", DoHMimeType)
+		w.Write(out)
+	}))
+
+	return ts
+}
+
+func TestDNSClientDigWithDoH(t *testing.T) {
+	assert := assert.New(t)
+
+	zone := map[string][]dns.RR{
+		"_mysql._tcp.example.com.": []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}},
+		},
+		"_http._tcp.example.com.": []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_http._tcp.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}},
+		},
+	}
+
+	for _, method := range []string{"GET", "POST"} {
+		methods := []string{}
+		ts := newDoHServer(zone, &methods)
+		var conns int32
+
+		ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+
+		ts.StartTLS()
+
+		config := &Config{
+			Domains:   []string{"_mysql._tcp.example.com", "_http._tcp.example.com", "_not_exist._tcp.example.com"},
+			DoHURL:    ts.URL + "/dns-query",
+			DoHMethod: method,
+			TLS:       TLSConfig{InsecureSkipVerify: true},
+		}
+
+		dnsCli, err := NewDNSClient(config)
+		assert.Equal(nil, err)
+		srvsByDomain := dnsCli.Dig()
+		assert.Equal("server1.example.com.", srvsByDomain["_mysql._tcp.example.com"][0].Target)
+		assert.Equal("server2.example.com.", srvsByDomain["_http._tcp.example.com"][0].Target)
+		assert.Equal(0, len(srvsByDomain["_not_exist._tcp.example.com"]))
+		assert.Equal(DomainReasonNotFound, dnsCli.Statuses["_not_exist._tcp.example.com"].Reason)
+		assert.Equal([]string{method, method, method}, methods)
+		assert.Equal(int32(1), atomic.LoadInt32(&conns))
+
+		// cached
+		dnsCli.Dig()
+		assert.Equal(4, len(methods))
+		ts.Close()
+	}
+}
+
+func TestDNSClientDigWithDoHTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	zone := map[string][]dns.RR{
+		"_mysql._tcp.example.com.": []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_mysql._tcp.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}},
+		},
+		"_http._tcp.example.com.": []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Name: "_http._tcp.example.com.", Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}},
+		},
+	}
+
+	methods := []string{}
+	handler := newDoHServer(zone, &methods).Config.Handler
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com", "_http._tcp.example.com"},
+		DoHURL:      ts.URL + "/dns-query",
+		DoHMethod:   "POST",
+		DNSTimeout:  3,
+		DNSAttempts: 1,
+		TLS:         TLSConfig{InsecureSkipVerify: true},
+		Domain: map[string]DomainConfig{
+			"_http._tcp.example.com": DomainConfig{Timeout: 1},
+		},
+	}
+
+	dnsCli, err := NewDNSClient(config)
+	assert.Equal(nil, err)
+	assert.Equal(3*time.Second, dnsCli.DoH.HTTPClient.Timeout)
+	assert.Equal(time.Second, dnsCli.doh("_http._tcp.example.com").HTTPClient.Timeout)
+	assert.Equal(dnsCli.DoH.HTTPClient.Transport, dnsCli.doh("_http._tcp.example.com").HTTPClient.Transport)
+
+	srvsByDomain := dnsCli.Dig()
+	assert.Equal("server1.example.com.", srvsByDomain["_mysql._tcp.example.com"][0].Target)
+	assert.Equal(0, len(srvsByDomain["_http._tcp.example.com"]))
+	assert.Equal(DomainReasonError, dnsCli.Statuses["_http._tcp.example.com"].Reason)
+
+	config.DNSTimeout = 0
+	dnsCli, err = NewDNSClient(config)
+	assert.Equal(nil, err)
+	assert.Equal(DefaultDoHTimeout, dnsCli.DoH.HTTPClient.Timeout)
+}
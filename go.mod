@@ -9,6 +9,7 @@ require (
 	github.com/dustin/go-jsonpointer v0.0.0-20160814072949-ba0abeacc3dc // indirect
 	github.com/dustin/gojson v0.0.0-20160307161227-2e71ec9dd5ad // indirect
 	github.com/flynn/go-shlex v0.0.0-20150515145356-3f9db97f8568 // indirect
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gliderlabs/sigil v0.4.0
 	github.com/mattn/go-shellwords v1.0.3
 	github.com/mgood/go-posix v0.0.0-20150821180505-948c005421f5 // indirect
@@ -18,5 +19,6 @@ require (
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/crypto v0.0.0-20180802221240-56440b844dfe // indirect
 	golang.org/x/net v0.0.0-20180801234040-f4c29de78a2a // indirect
-	gopkg.in/yaml.v2 v2.2.1 // indirect
+	golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
+	gopkg.in/yaml.v2 v2.2.1
 )
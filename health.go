@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// HealthBackoffBase is the time to deprioritize the resolver after the first failure.
+	HealthBackoffBase = 5 * time.Second
+	// HealthBackoffMax is the maximum time to deprioritize the resolver.
+	HealthBackoffMax = 5 * time.Minute
+	// HealthLatencyWeight is the weight of the latest response time in the average latency.
+	HealthLatencyWeight = 0.3
+)
+
+// ServerHealth struct has the query results of the resolver.
+type ServerHealth struct {
+	Successes           uint64
+	Failures            uint64
+	ConsecutiveFailures uint64 `json:",omitempty"`
+	LatencySeconds      float64
+	BackoffUntil        *time.Time `json:",omitempty"`
+}
+
+// recordSuccess records the successful query and its response time.
+func (health *ServerHealth) recordSuccess(latency time.Duration) {
+	if health.Successes == 0 {
+		health.LatencySeconds = latency.Seconds()
+	} else {
+		// Exponentially weighted moving average
+		health.LatencySeconds = HealthLatencyWeight*latency.Seconds() + (1-HealthLatencyWeight)*health.LatencySeconds
+	}
+
+	health.Successes++
+	health.ConsecutiveFailures = 0
+	health.BackoffUntil = nil
+}
+
+// recordFailure records the failed query and deprioritizes the resolver with exponential backoff.
+func (health *ServerHealth) recordFailure(now time.Time) (backoff time.Duration) {
+	health.Failures++
+	health.ConsecutiveFailures++
+	backoff = HealthBackoffBase
+
+	for i := uint64(1); i < health.ConsecutiveFailures && backoff < HealthBackoffMax; i++ {
+		backoff *= 2
+	}
+
+	if backoff > HealthBackoffMax {
+		backoff = HealthBackoffMax
+	}
+
+	until := now.Add(backoff)
+	health.BackoffUntil = &until
+	return
+}
+
+// isBackedOff returns whether the resolver is deprioritized.
+func (health *ServerHealth) isBackedOff(now time.Time) bool {
+	return health.BackoffUntil != nil && now.Before(*health.BackoffUntil)
+}
+
+// serverHealth returns the health of the resolver.
+func (dnsCli *DNSClient) serverHealth(server string) *ServerHealth {
+	health, ok := dnsCli.Health[server]
+
+	if !ok {
+		health = &ServerHealth{}
+		dnsCli.Health[server] = health
+	}
+
+	return health
+}
+
+func (dnsCli *DNSClient) recordSuccess(server string, latency time.Duration) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	health := dnsCli.serverHealth(server)
+
+	if health.ConsecutiveFailures > 0 {
+		log.Printf("The resolver %s has recovered", server)
+	}
+
+	health.recordSuccess(latency)
+}
+
+func (dnsCli *DNSClient) recordFailure(server string) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	health := dnsCli.serverHealth(server)
+	backoff := health.recordFailure(time.Now())
+	log.Printf("WARNING: The resolver %s is deprioritized for %s after %d consecutive failures", server, backoff, health.ConsecutiveFailures)
+}
+
+// orderByHealth moves the deprioritized resolvers to the end.
+// The caller must hold the mutex.
+// Once the backoff expires, the resolver returns to its position and is probed by the next query.
+func (dnsCli *DNSClient) orderByHealth(servers []string) (ordered []string) {
+	now := time.Now()
+	backedOff := []string{}
+
+	for _, server := range servers {
+		if health, ok := dnsCli.Health[server]; ok && health.isBackedOff(now) {
+			backedOff = append(backedOff, server)
+		} else {
+			ordered = append(ordered, server)
+		}
+	}
+
+	ordered = append(ordered, backedOff...)
+	return
+}
+
+// ServerHealths returns a copy of the health of the resolvers.
+func (dnsCli *DNSClient) ServerHealths() (healths map[string]ServerHealth) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+	healths = make(map[string]ServerHealth, len(dnsCli.Health))
+
+	for server, health := range dnsCli.Health {
+		healths[server] = *health
+	}
+
+	return
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func TestServerHealthRecordFailure(t *testing.T) {
+	assert := assert.New(t)
+	health := &ServerHealth{}
+	now := time.Now()
+
+	assert.Equal(5*time.Second, health.recordFailure(now))
+	assert.Equal(10*time.Second, health.recordFailure(now))
+	assert.Equal(20*time.Second, health.recordFailure(now))
+	assert.True(health.isBackedOff(now.Add(19 * time.Second)))
+	assert.False(health.isBackedOff(now.Add(20 * time.Second)))
+
+	for i := 0; i < 10; i++ {
+		health.recordFailure(now)
+	}
+
+	assert.Equal(HealthBackoffMax, health.recordFailure(now))
+	assert.Equal(uint64(14), health.Failures)
+
+	health.recordSuccess(100 * time.Millisecond)
+	health.recordSuccess(200 * time.Millisecond)
+	assert.Equal(uint64(0), health.ConsecutiveFailures)
+	assert.Nil(health.BackoffUntil)
+	assert.InDelta(0.13, health.LatencySeconds, 0.0001)
+}
+
+func TestDNSClientDigWithDeadServer(t *testing.T) {
+	assert := assert.New(t)
+	aliveAddr, shutdown := testutils.StartDNSServer("tcp", srvHandler(0), nil)
+	defer shutdown()
+
+	// Connection to the closed port is refused
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := listener.Addr().String()
+	listener.Close()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com"},
+		Net:         "tcp",
+		Nameservers: []string{deadAddr, aliveAddr},
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	assert.Equal(1, len(dnsCli.Dig()["_mysql._tcp.example.com"]))
+	assert.Equal(uint64(1), dnsCli.Health[deadAddr].Failures)
+	assert.Equal(uint64(1), dnsCli.Health[aliveAddr].Successes)
+	assert.True(dnsCli.Health[deadAddr].isBackedOff(time.Now()))
+
+	// The dead server is deprioritized
+	assert.Equal([]string{aliveAddr, deadAddr}, dnsCli.servers("_mysql._tcp.example.com"))
+	assert.Equal(1, len(dnsCli.Dig()["_mysql._tcp.example.com"]))
+	assert.Equal(uint64(1), dnsCli.Health[deadAddr].Failures)
+	assert.Equal(uint64(2), dnsCli.Health[aliveAddr].Successes)
+
+	// The dead server is probed again after the backoff
+	expired := time.Now().Add(-time.Second)
+	dnsCli.Health[deadAddr].BackoffUntil = &expired
+	assert.Equal(1, len(dnsCli.Dig()["_mysql._tcp.example.com"]))
+	assert.Equal(uint64(2), dnsCli.Health[deadAddr].Failures)
+	assert.Equal(10*time.Second, dnsCli.Health[deadAddr].BackoffUntil.Sub(time.Now()).Round(time.Second))
+}
+
+func TestWritePrometheusWithServers(t *testing.T) {
+	assert := assert.New(t)
+	backoffUntil := time.Now().Add(time.Minute)
+
+	status := &Status{
+		Servers: map[string]ServerHealth{
+			"10.0.0.2:53": ServerHealth{Successes: 3, Failures: 1, LatencySeconds: 0.5},
+			"10.0.0.1:53": ServerHealth{Failures: 2, ConsecutiveFailures: 2, BackoffUntil: &backoffUntil},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	WritePrometheus(buf, status)
+	metrics := buf.String()
+
+	for _, line := range []string{
+		`srvd_dns_server_successes_total{server="10.0.0.1:53"} 0`,
+		`srvd_dns_server_successes_total{server="10.0.0.2:53"} 3`,
+		`srvd_dns_server_failures_total{server="10.0.0.1:53"} 2`,
+		`srvd_dns_server_latency_seconds{server="10.0.0.2:53"} 0.5`,
+		`srvd_dns_server_up{server="10.0.0.1:53"} 0`,
+		`srvd_dns_server_up{server="10.0.0.2:53"} 1`,
+	} {
+		assert.True(strings.Contains(metrics, line+"\n"), line)
+	}
+
+	assert.True(strings.Index(metrics, `{server="10.0.0.1:53"}`) < strings.Index(metrics, `{server="10.0.0.2:53"}`))
+}
@@ -36,12 +36,18 @@ func (httpd *Httpd) handler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, string(status))
 }
 
+func (httpd *Httpd) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WritePrometheus(w, httpd.Status)
+}
+
 // Run executes httpd.
 func (httpd *Httpd) Run() {
 	go httpd.updateStatus()
 
 	if !httpd.Config.Nohttpd {
 		http.HandleFunc("/status", httpd.handler)
+		http.HandleFunc("/metrics", httpd.metricsHandler)
 		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", httpd.Config.StatusPort), nil))
 	}
 }
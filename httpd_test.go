@@ -35,3 +35,43 @@ func TestHttpdOk(t *testing.T) {
 	assert.Equal(200, code)
 	assert.Equal(`{"LastUpdate":"2014-12-31T12:13:24Z","Ok":true}`+"\n", body)
 }
+
+func TestHttpdDomains(t *testing.T) {
+	assert := assert.New(t)
+
+	httpd := &Httpd{Status: &Status{
+		LastUpdate: time.Date(2014, time.December, 31, 12, 13, 24, 0, time.UTC),
+		Ok:         true,
+		Domains:    map[string]DomainStatus{"_mysql._tcp.example.com": DomainStatus{Reason: DomainReasonUnavailable}},
+	}}
+
+	mtx := http.NewServeMux()
+	mtx.HandleFunc("/status", httpd.handler)
+	ts := httptest.NewServer(mtx)
+	defer ts.Close()
+	res, _ := http.Get(ts.URL + "/status")
+	body, code := testutils.ReadResponse(res)
+	assert.Equal(200, code)
+	assert.Equal(`{"LastUpdate":"2014-12-31T12:13:24Z","Ok":true,"Domains":{"_mysql._tcp.example.com":{"Reason":"unavailable"}}}`+"\n", body)
+}
+
+func TestHttpdMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	httpd := &Httpd{Status: &Status{
+		LastUpdate: time.Date(2014, time.December, 31, 12, 13, 24, 0, time.UTC),
+		Ok:         true,
+		Metrics:    &Metrics{TCPFallbacks: 3},
+	}}
+
+	mtx := http.NewServeMux()
+	mtx.HandleFunc("/metrics", httpd.metricsHandler)
+	ts := httptest.NewServer(mtx)
+	defer ts.Close()
+	res, _ := http.Get(ts.URL + "/metrics")
+	body, code := testutils.ReadResponse(res)
+	assert.Equal(200, code)
+	assert.Contains(body, "srvd_ok 1\n")
+	assert.Contains(body, "srvd_last_update_timestamp_seconds 1420028004\n")
+	assert.Contains(body, "# TYPE srvd_dns_tcp_fallbacks_total counter\nsrvd_dns_tcp_fallbacks_total 3\n")
+}
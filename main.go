@@ -2,15 +2,19 @@ package main
 
 import (
 	"log"
+	"math/rand"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/okzk/sdnotify"
 )
 
 func init() {
 	log.SetFlags(log.LstdFlags)
+	// The jitter and the splay must differ between the hosts
+	rand.Seed(time.Now().UnixNano())
 }
 
 func main() {
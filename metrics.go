@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Metrics struct has the counters of DNS lookups.
+type Metrics struct {
+	TCPFallbacks   uint64
+	Prefetches     uint64
+	PrefetchHits   uint64
+	PrefetchMisses uint64
+}
+
+// WritePrometheus writes the status and metrics in the Prometheus text format.
+func WritePrometheus(w io.Writer, status *Status) {
+	ok := 0
+
+	if status.Ok {
+		ok = 1
+	}
+
+	writeMetric(w, "srvd_ok", "gauge", "Whether the last update succeeded.", ok)
+	writeMetric(w, "srvd_last_update_timestamp_seconds", "gauge", "Unix time of the last update.", status.LastUpdate.Unix())
+
+	metrics := status.Metrics
+
+	if metrics == nil {
+		metrics = &Metrics{}
+	}
+
+	writeMetric(w, "srvd_dns_tcp_fallbacks_total", "counter", "Number of retries over TCP for truncated UDP responses.", metrics.TCPFallbacks)
+	writeMetric(w, "srvd_dns_prefetches_total", "counter", "Number of background refreshes of expiring cache entries.", metrics.Prefetches)
+	writeMetric(w, "srvd_dns_prefetch_hits_total", "counter", "Number of cache entries refreshed by prefetch before they expired.", metrics.PrefetchHits)
+	writeMetric(w, "srvd_dns_prefetch_misses_total", "counter", "Number of cache entries expired before they were refreshed by prefetch.", metrics.PrefetchMisses)
+
+	if len(status.Servers) == 0 {
+		return
+	}
+
+	servers := make([]string, 0, len(status.Servers))
+
+	for server := range status.Servers {
+		servers = append(servers, server)
+	}
+
+	sort.Strings(servers)
+	now := time.Now()
+
+	writeServerMetric(w, "srvd_dns_server_successes_total", "counter", "Number of successful queries to the resolver.", servers, func(server string) interface{} {
+		return status.Servers[server].Successes
+	})
+
+	writeServerMetric(w, "srvd_dns_server_failures_total", "counter", "Number of failed queries to the resolver.", servers, func(server string) interface{} {
+		return status.Servers[server].Failures
+	})
+
+	writeServerMetric(w, "srvd_dns_server_latency_seconds", "gauge", "Average response time of the resolver.", servers, func(server string) interface{} {
+		return status.Servers[server].LatencySeconds
+	})
+
+	writeServerMetric(w, "srvd_dns_server_up", "gauge", "Whether the resolver is not deprioritized by failures.", servers, func(server string) interface{} {
+		health := status.Servers[server]
+
+		if health.isBackedOff(now) {
+			return 0
+		}
+
+		return 1
+	})
+}
+
+func writeServerMetric(w io.Writer, name string, typ string, help string, servers []string, value func(string) interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+
+	for _, server := range servers {
+		fmt.Fprintf(w, "%s{server=%q} %v\n", name, server, value(server))
+	}
+}
+
+func writeMetric(w io.Writer, name string, typ string, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(w, "%s %v\n", name, value)
+}
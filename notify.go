@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// NotifyConfig struct has the settings of the DNS NOTIFY listener ([notify]).
+type NotifyConfig struct {
+	Listen         string
+	Zones          []string
+	TSIGName       string `toml:"tsig_name"`
+	TSIGAlgorithm  string `toml:"tsig_algorithm"`
+	TSIGSecret     string `toml:"tsig_secret"`
+	TSIGSecretFile string `toml:"tsig_secret_file"`
+}
+
+// validateNotifyConfig validates [notify] table and normalizes the zones and the TSIG key.
+func validateNotifyConfig(config *NotifyConfig) (err error) {
+	if config.Listen == "" {
+		return
+	}
+
+	if _, _, e := net.SplitHostPort(config.Listen); e != nil {
+		err = fmt.Errorf("notify.listen must be 'host:port': %s", e)
+		return
+	}
+
+	if len(config.Zones) == 0 {
+		err = fmt.Errorf("notify.zones is required")
+		return
+	}
+
+	for i, zone := range config.Zones {
+		config.Zones[i] = dns.Fqdn(strings.ToLower(zone))
+	}
+
+	if config.TSIGName == "" {
+		return
+	}
+
+	config.TSIGName = dns.Fqdn(strings.ToLower(config.TSIGName))
+
+	if config.TSIGAlgorithm == "" {
+		config.TSIGAlgorithm = DefaultTSIGAlgorithm
+	} else {
+		config.TSIGAlgorithm = dns.Fqdn(strings.ToLower(config.TSIGAlgorithm))
+	}
+
+	if !TSIGAlgorithms[config.TSIGAlgorithm] {
+		err = fmt.Errorf("Unsupported notify.tsig_algorithm: %s", config.TSIGAlgorithm)
+		return
+	}
+
+	if config.TSIGSecretFile != "" {
+		config.TSIGSecret, err = LoadTSIGSecret(config.TSIGSecretFile)
+
+		if err != nil {
+			err = fmt.Errorf("notify.tsig_secret_file loading failed: %s", err)
+			return
+		}
+	}
+
+	if config.TSIGSecret == "" {
+		err = fmt.Errorf("notify.tsig_secret or notify.tsig_secret_file is required")
+	}
+
+	return
+}
+
+// NotifyListener struct has the UDP/TCP servers which accept DNS NOTIFY messages (RFC 1996).
+type NotifyListener struct {
+	Config   *NotifyConfig
+	WakeChan chan bool
+	zones    map[string]bool
+	pending  map[string]bool
+	servers  []*dns.Server
+	mutex    sync.Mutex
+}
+
+// NewNotifyListener creates NotifyListener struct and binds the UDP and TCP sockets.
+func NewNotifyListener(config *NotifyConfig) (listener *NotifyListener, err error) {
+	listener = &NotifyListener{
+		Config:   config,
+		WakeChan: make(chan bool, 1),
+		zones:    map[string]bool{},
+		pending:  map[string]bool{},
+	}
+
+	for _, zone := range config.Zones {
+		listener.zones[zone] = true
+	}
+
+	var tsigSecret map[string]string
+
+	if config.TSIGName != "" {
+		tsigSecret = map[string]string{config.TSIGName: config.TSIGSecret}
+	}
+
+	pc, err := net.ListenPacket("udp", config.Listen)
+
+	if err != nil {
+		return
+	}
+
+	// The TCP socket listens on the same port even if the port is 0 (e.g. tests)
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+
+	if err != nil {
+		pc.Close()
+		return
+	}
+
+	listener.servers = []*dns.Server{
+		{PacketConn: pc, Handler: listener, TsigSecret: tsigSecret},
+		{Listener: l, Handler: listener, TsigSecret: tsigSecret},
+	}
+
+	return
+}
+
+// Addr returns the listening address.
+func (listener *NotifyListener) Addr() string {
+	return listener.servers[0].PacketConn.LocalAddr().String()
+}
+
+// Run starts serving the NOTIFY messages and waits for the servers to start.
+func (listener *NotifyListener) Run() {
+	var started sync.WaitGroup
+
+	for _, server := range listener.servers {
+		started.Add(1)
+		server.NotifyStartedFunc = started.Done
+
+		go func(server *dns.Server) {
+			err := server.ActivateAndServe()
+
+			if err != nil {
+				log.Println("WARNING: NOTIFY listener stopped:", err)
+			}
+		}(server)
+	}
+
+	started.Wait()
+}
+
+// Close stops the servers.
+func (listener *NotifyListener) Close() {
+	for _, server := range listener.servers {
+		server.Shutdown()
+	}
+}
+
+// ServeDNS accepts the NOTIFY of the configured zones and wakes the worker.
+func (listener *NotifyListener) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := &dns.Msg{}
+
+	if req.Opcode != dns.OpcodeNotify || len(req.Question) != 1 {
+		w.WriteMsg(m.SetRcode(req, dns.RcodeNotImplemented))
+		return
+	}
+
+	zone := strings.ToLower(req.Question[0].Name)
+
+	if !listener.zones[zone] {
+		log.Printf("WARNING: NOTIFY of the unknown zone %s from %s was refused", zone, w.RemoteAddr())
+		w.WriteMsg(m.SetRcode(req, dns.RcodeRefused))
+		return
+	}
+
+	if listener.Config.TSIGName != "" {
+		tsig := req.IsTsig()
+
+		if tsig == nil || w.TsigStatus() != nil || strings.ToLower(tsig.Hdr.Name) != listener.Config.TSIGName {
+			log.Printf("WARNING: NOTIFY of %s from %s was refused: not signed with the TSIG key", zone, w.RemoteAddr())
+			m.SetRcode(req, dns.RcodeNotAuth)
+			w.WriteMsg(m)
+			return
+		}
+	}
+
+	m.SetReply(req)
+	m.Authoritative = true
+
+	if listener.Config.TSIGName != "" {
+		m.SetTsig(listener.Config.TSIGName, listener.Config.TSIGAlgorithm, TSIGFudge, time.Now().Unix())
+	}
+
+	w.WriteMsg(m)
+	log.Printf("NOTIFY of %s was received from %s", zone, w.RemoteAddr())
+
+	listener.mutex.Lock()
+	listener.pending[zone] = true
+	listener.mutex.Unlock()
+
+	// The worker collects all pending zones at once
+	select {
+	case listener.WakeChan <- true:
+	default:
+	}
+}
+
+// PendingZones returns the notified zones and clears them.
+func (listener *NotifyListener) PendingZones() (zones []string) {
+	listener.mutex.Lock()
+	defer listener.mutex.Unlock()
+
+	for zone := range listener.pending {
+		zones = append(zones, zone)
+	}
+
+	listener.pending = map[string]bool{}
+	return
+}
+
+// Invalidate removes the cache and the backoff of the domains and the browsed services in the zone so that they are queried by the next Dig and Browse.
+func (dnsCli *DNSClient) Invalidate(zone string) (domains []string) {
+	dnsCli.mutex.Lock()
+	defer dnsCli.mutex.Unlock()
+
+	for domain, names := range dnsCli.Names {
+		for _, name := range names {
+			if dns.IsSubDomain(zone, name) {
+				delete(dnsCli.Cache, domain)
+				delete(dnsCli.Backoffs, domain)
+				domains = append(domains, domain)
+				break
+			}
+		}
+	}
+
+	for service, msg := range dnsCli.Services {
+		if dns.IsSubDomain(zone, msg.Question[0].Name) {
+			delete(dnsCli.BrowseCaches, service)
+			delete(dnsCli.BrowseBackoffs, service)
+			domains = append(domains, service)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func startNotifyListener(config *NotifyConfig) (listener *NotifyListener) {
+	config.Listen = "127.0.0.1:0"
+	validateNotifyConfig(config)
+	listener, _ = NewNotifyListener(config)
+	listener.Run()
+	return
+}
+
+func TestNotifyListener(t *testing.T) {
+	assert := assert.New(t)
+	listener := startNotifyListener(&NotifyConfig{Zones: []string{"Example.com"}})
+	defer listener.Close()
+
+	for _, network := range []string{"udp", "tcp"} {
+		client := &dns.Client{Net: network}
+		notify := &dns.Msg{}
+		notify.SetNotify("example.com.")
+		r, _, err := client.Exchange(notify, listener.Addr())
+		assert.Equal(nil, err)
+		assert.Equal(dns.RcodeSuccess, r.Rcode)
+		assert.True(r.Authoritative)
+
+		select {
+		case <-listener.WakeChan:
+		case <-time.After(time.Second):
+			assert.Fail("the worker is not woken")
+		}
+
+		assert.Equal([]string{"example.com."}, listener.PendingZones())
+		assert.Equal(0, len(listener.PendingZones()))
+	}
+
+	client := &dns.Client{}
+	notify := &dns.Msg{}
+	notify.SetNotify("example.org.")
+	r, _, _ := client.Exchange(notify, listener.Addr())
+	assert.Equal(dns.RcodeRefused, r.Rcode)
+
+	query := &dns.Msg{}
+	query.SetQuestion("example.com.", dns.TypeSOA)
+	r, _, _ = client.Exchange(query, listener.Addr())
+	assert.Equal(dns.RcodeNotImplemented, r.Rcode)
+
+	assert.Equal(0, len(listener.PendingZones()))
+}
+
+func TestNotifyListenerWithTSIG(t *testing.T) {
+	assert := assert.New(t)
+	secret := "c3J2ZC10ZXN0LXNlY3JldA=="
+
+	listener := startNotifyListener(&NotifyConfig{
+		Zones:      []string{"example.com"},
+		TSIGName:   "srvd-key",
+		TSIGSecret: secret,
+	})
+
+	defer listener.Close()
+
+	// Unsigned
+	client := &dns.Client{}
+	notify := &dns.Msg{}
+	notify.SetNotify("example.com.")
+	r, _, _ := client.Exchange(notify, listener.Addr())
+	assert.Equal(dns.RcodeNotAuth, r.Rcode)
+
+	// Signed with the wrong secret
+	client = &dns.Client{TsigSecret: map[string]string{"srvd-key.": "d3Jvbmctc2VjcmV0"}}
+	client.Exchange(signTSIG(notify, "srvd-key.", dns.HmacSHA256), listener.Addr())
+	assert.Equal(0, len(listener.PendingZones()))
+
+	client = &dns.Client{TsigSecret: map[string]string{"srvd-key.": secret}}
+	r, _, err := client.Exchange(signTSIG(notify, "srvd-key.", dns.HmacSHA256), listener.Addr())
+	assert.Equal(nil, err)
+	assert.Equal(dns.RcodeSuccess, r.Rcode)
+	assert.NotNil(r.IsTsig())
+	assert.Equal([]string{"example.com."}, listener.PendingZones())
+}
+
+func TestDNSClientInvalidate(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:     []string{"_http._tcp.example.com", "_http._tcp.example.org"},
+		Browse:      []string{"_ftp._tcp.example.com"},
+		Nameservers: []string{"127.0.0.1"},
+	}
+
+	dnsCli, _ := NewDNSClient(config)
+	dnsCli.Cache["_http._tcp.example.com"] = &SRVCache{}
+	dnsCli.Cache["_http._tcp.example.org"] = &SRVCache{}
+	dnsCli.Backoffs["_http._tcp.example.com"] = &DomainBackoff{}
+	dnsCli.BrowseCaches["_ftp._tcp.example.com"] = &BrowseCache{}
+
+	assert.Equal([]string{"_http._tcp.example.com", "_ftp._tcp.example.com"}, dnsCli.Invalidate("example.com."))
+	assert.Equal(1, len(dnsCli.Cache))
+	assert.Equal(0, len(dnsCli.Backoffs))
+	assert.Equal(0, len(dnsCli.BrowseCaches))
+	assert.Equal(0, len(dnsCli.Invalidate("example.net.")))
+}
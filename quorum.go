@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ResolverModeFirst uses the first answer.
+	ResolverModeFirst = "first"
+	// ResolverModeAllAgree uses the answer only if all resolvers return the same SRV records.
+	ResolverModeAllAgree = "all_agree"
+	// ResolverModeMajority uses the SRV records returned by the majority of the resolvers.
+	ResolverModeMajority = "majority"
+	// ResolverModeUnion uses all SRV records returned by the resolvers.
+	ResolverModeUnion = "union"
+)
+
+// quorumAnswer is the SRV records returned by the resolvers.
+type quorumAnswer struct {
+	srvs    []*dns.SRV
+	servers []string
+}
+
+// srvsKey returns the string to compare the SRV records regardless of TTL and order.
+func srvsKey(srvs []*dns.SRV) string {
+	keys := make([]string, 0, len(srvs))
+
+	for _, srv := range srvs {
+		keys = append(keys, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, strings.ToLower(srv.Target)))
+	}
+
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// resolveQuorum queries all resolvers and merges the answers according to resolver_mode.
+// An NXDOMAIN or empty answer counts as an answer without SRV records, and the minimum TTL of them is returned.
+// A resolver that fails (or whose answer is rejected) does not count.
+func (dnsCli *DNSClient) resolveQuorum(domain string, msg *dns.Msg, status *DomainStatus) (srvs []*dns.SRV, negTTL int64, err error) {
+	negTTL = -1
+	servers := dnsCli.servers(domain)
+	answers := map[string]*quorumAnswer{}
+	keys := []string{}
+	answered := 0
+	var lastErr error
+
+	for _, server := range servers {
+		var r *dns.Msg
+
+		for attempt := 0; attempt < dnsCli.Attempts; attempt++ {
+			res, retry, e := dnsCli.queryServer(domain, msg, server, status)
+
+			if e != nil {
+				lastErr = e
+			}
+
+			if res != nil || !retry {
+				r = res
+				break
+			}
+		}
+
+		if r == nil {
+			continue
+		}
+
+		serverSRVs := []*dns.SRV{}
+
+		if r.Rcode == dns.RcodeSuccess {
+			e := dnsCli.checkDNSSEC(domain, msg, r, server, status)
+
+			if e == nil && len(r.Answer) > 0 {
+				serverSRVs, e = extractSRVs(msg.Question[0].Name, r.Answer)
+			}
+
+			if e != nil {
+				log.Printf("WARNING: The answer from %s is rejected: %s", server, e)
+				lastErr = e
+				continue
+			}
+		}
+
+		answered++
+		key := srvsKey(serverSRVs)
+
+		if len(serverSRVs) == 0 {
+			if t := negativeTTL(r); t >= 0 && (negTTL < 0 || t < negTTL) {
+				negTTL = t
+			}
+		}
+
+		if answer, ok := answers[key]; ok {
+			answer.servers = append(answer.servers, server)
+		} else {
+			answers[key] = &quorumAnswer{srvs: serverSRVs, servers: []string{server}}
+			keys = append(keys, key)
+		}
+	}
+
+	if answered == 0 {
+		err = lastErr
+		return
+	}
+
+	if len(answers) > 1 {
+		details := make([]string, 0, len(keys))
+
+		for _, key := range keys {
+			details = append(details, fmt.Sprintf("%s=[%s]", strings.Join(answers[key].servers, " "), key))
+		}
+
+		status.Warning = fmt.Sprintf("Resolvers disagree on %s: %s", msg.Question[0].Name, strings.Join(details, "; "))
+		log.Printf("WARNING: %s", status.Warning)
+	}
+
+	switch dnsCli.ResolverMode {
+	case ResolverModeAllAgree:
+		if len(answers) > 1 {
+			err = fmt.Errorf("Resolvers disagree (resolver_mode = %s)", ResolverModeAllAgree)
+		} else if answered < len(servers) {
+			err = fmt.Errorf("%d of %d resolvers did not answer (resolver_mode = %s)", len(servers)-answered, len(servers), ResolverModeAllAgree)
+		} else {
+			srvs = answers[keys[0]].srvs
+		}
+	case ResolverModeMajority:
+		for _, key := range keys {
+			if len(answers[key].servers)*2 > len(servers) {
+				srvs = answers[key].srvs
+				return
+			}
+		}
+
+		err = fmt.Errorf("No answer is returned by the majority of %d resolvers (resolver_mode = %s)", len(servers), ResolverModeMajority)
+	case ResolverModeUnion:
+		seen := map[string]bool{}
+
+		for _, key := range keys {
+			for _, srv := range answers[key].srvs {
+				k := srvsKey([]*dns.SRV{srv})
+
+				if !seen[k] {
+					seen[k] = true
+					srvs = append(srvs, srv)
+				}
+			}
+		}
+
+		// The service is not decidedly unavailable if another resolver returns the targets
+		if len(srvs) > 1 {
+			available := []*dns.SRV{}
+
+			for _, srv := range srvs {
+				if srv.Target != "." {
+					available = append(available, srv)
+				}
+			}
+
+			srvs = available
+		}
+	}
+
+	return
+}
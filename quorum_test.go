@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func newQuorumDNSClient(mode string, handlers ...dns.Handler) (dnsCli *DNSClient, servers []string, shutdown func()) {
+	shutdowns := []func(){}
+
+	for _, handler := range handlers {
+		addr, s := testutils.StartDNSServer("tcp", handler, nil)
+		servers = append(servers, addr)
+		shutdowns = append(shutdowns, s)
+	}
+
+	config := &Config{
+		Domains:      []string{"_mysql._tcp.example.com"},
+		Net:          "tcp",
+		Nameservers:  servers,
+		ResolverMode: mode,
+	}
+
+	dnsCli, _ = NewDNSClient(config)
+
+	shutdown = func() {
+		for _, s := range shutdowns {
+			s()
+		}
+	}
+
+	return
+}
+
+func targets(srvs []*dns.SRV) (ts []string) {
+	for _, srv := range srvs {
+		ts = append(ts, srv.Target)
+	}
+
+	return
+}
+
+func TestDNSClientDigWithAllAgree(t *testing.T) {
+	assert := assert.New(t)
+
+	dnsCli, _, shutdown := newQuorumDNSClient(ResolverModeAllAgree, targetHandler("server1.example.com."), targetHandler("server1.example.com."))
+	defer shutdown()
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]string{"server1.example.com."}, targets(srvs))
+	assert.Equal("", dnsCli.Statuses["_mysql._tcp.example.com"].Warning)
+
+	dnsCli, servers, shutdown := newQuorumDNSClient(ResolverModeAllAgree, targetHandler("server1.example.com."), targetHandler("server2.example.com."))
+	defer shutdown()
+	srvs = dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+
+	assert.Equal(DomainStatus{
+		Reason:  DomainReasonError,
+		Error:   "Resolvers disagree (resolver_mode = all_agree)",
+		FQDN:    "_mysql._tcp.example.com.",
+		Warning: "Resolvers disagree on _mysql._tcp.example.com.: " + servers[0] + "=[10 100 80 server1.example.com.]; " + servers[1] + "=[10 100 80 server2.example.com.]",
+	}, *dnsCli.Statuses["_mysql._tcp.example.com"])
+}
+
+func TestDNSClientDigWithMajority(t *testing.T) {
+	assert := assert.New(t)
+
+	dnsCli, _, shutdown := newQuorumDNSClient(ResolverModeMajority, targetHandler("server1.example.com."), targetHandler("server2.example.com."), targetHandler("server1.example.com."))
+	defer shutdown()
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]string{"server1.example.com."}, targets(srvs))
+	status := dnsCli.Statuses["_mysql._tcp.example.com"]
+	assert.Equal(DomainReasonOK, status.Reason)
+	assert.NotEqual("", status.Warning)
+
+	dnsCli, _, shutdown = newQuorumDNSClient(ResolverModeMajority, targetHandler("server1.example.com."), targetHandler("server2.example.com."))
+	defer shutdown()
+	srvs = dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+	assert.Equal("No answer is returned by the majority of 2 resolvers (resolver_mode = majority)", dnsCli.Statuses["_mysql._tcp.example.com"].Error)
+}
+
+func TestDNSClientDigWithUnion(t *testing.T) {
+	assert := assert.New(t)
+
+	dnsCli, _, shutdown := newQuorumDNSClient(ResolverModeUnion, targetHandler("server1.example.com."), targetHandler("server2.example.com."), targetHandler("server1.example.com."))
+	defer shutdown()
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal([]string{"server1.example.com.", "server2.example.com."}, targets(srvs))
+	status := dnsCli.Statuses["_mysql._tcp.example.com"]
+	assert.Equal(DomainReasonOK, status.Reason)
+	assert.NotEqual("", status.Warning)
+}
+
+func TestSRVsKey(t *testing.T) {
+	assert := assert.New(t)
+
+	srvs1 := []*dns.SRV{
+		&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
+		&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Ttl: 3}},
+	}
+
+	srvs2 := []*dns.SRV{
+		&dns.SRV{Priority: 10, Weight: 100, Target: "Server1.example.com.", Port: 80, Hdr: dns.RR_Header{Ttl: 30}},
+		&dns.SRV{Priority: 10, Weight: 100, Target: "server2.example.com.", Port: 80, Hdr: dns.RR_Header{Ttl: 30}},
+	}
+
+	assert.Equal(srvsKey(srvs1), srvsKey(srvs2))
+	assert.Equal("10 100 80 server1.example.com., 10 100 80 server2.example.com.", srvsKey(srvs1))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// loadResolvOptions returns the options of resolv.conf (e.g. "ndots:2" is returned as {"ndots": "2"}).
+// dns.ClientConfig has the values, but it cannot tell whether the options are specified.
+func loadResolvOptions(path string) (options map[string]string, err error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+	options = map[string]string{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 1 || fields[0] != "options" {
+			continue
+		}
+
+		for _, option := range fields[1:] {
+			kv := strings.SplitN(option, ":", 2)
+
+			if len(kv) == 2 {
+				options[kv[0]] = kv[1]
+			} else {
+				options[kv[0]] = ""
+			}
+		}
+	}
+
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func TestLoadResolvOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("nameserver 127.0.0.1\noptions ndots:2 rotate\noptions timeout:1\n", func(f *os.File) {
+		options, err := loadResolvOptions(f.Name())
+		assert.Equal(nil, err)
+		assert.Equal(map[string]string{"ndots": "2", "rotate": "", "timeout": "1"}, options)
+	})
+}
+
+func TestNewDNSClientWithResolvOptionsOverridden(t *testing.T) {
+	assert := assert.New(t)
+	rotate := false
+
+	testutils.TempFile("nameserver 127.0.0.1\noptions attempts:3 timeout:1 rotate\n", func(f *os.File) {
+		config := &Config{
+			Domains:     []string{"_mysql._tcp.example.com"},
+			ResolvConf:  f.Name(),
+			DNSTimeout:  4,
+			DNSAttempts: 2,
+			DNSRotate:   &rotate,
+		}
+
+		dnsCli, _ := NewDNSClient(config)
+		assert.Equal(2, dnsCli.Attempts)
+		assert.Equal(false, dnsCli.Rotate)
+		assert.Equal(4*time.Second, dnsCli.Client.Timeout)
+		assert.Equal(4*time.Second, dnsCli.TCPClient.Timeout)
+	})
+}
+
+func TestNewDNSClientWithoutResolvOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("nameserver 127.0.0.1\n", func(f *os.File) {
+		config := &Config{
+			Domains:    []string{"_mysql._tcp.example.com"},
+			ResolvConf: f.Name(),
+		}
+
+		dnsCli, _ := NewDNSClient(config)
+		assert.Equal(1, dnsCli.Attempts)
+		assert.Equal(false, dnsCli.Rotate)
+		assert.Equal(time.Duration(0), dnsCli.Client.Timeout)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DomainResolver struct has the resolvers and the clients of the domain overridden in [domain."name"].
+type DomainResolver struct {
+	Nameservers []string
+	Port        string
+	Client      *dns.Client
+	TCPClient   *dns.Client
+	DoH         *DoHClient
+}
+
+// loadClientConfig loads resolv.conf.
+// If nameservers is specified, resolv.conf is optional and the defaults of the system resolver are used without it.
+func loadClientConfig(config *Config) (clientConfig *dns.ClientConfig, err error) {
+	clientConfig, err = dns.ClientConfigFromFile(config.ResolvConf)
+
+	if os.IsNotExist(err) && len(config.Nameservers) > 0 {
+		clientConfig = &dns.ClientConfig{Port: "53", Ndots: 1, Timeout: 5, Attempts: 2}
+		err = nil
+	}
+
+	return
+}
+
+// normalizeNameservers appends the port to the nameservers without the port.
+func normalizeNameservers(nameservers []string, port string) (hostPorts []string) {
+	for _, nameserver := range nameservers {
+		if _, _, err := net.SplitHostPort(nameserver); err == nil {
+			hostPorts = append(hostPorts, nameserver)
+		} else {
+			hostPorts = append(hostPorts, net.JoinHostPort(nameserver, port))
+		}
+	}
+
+	return
+}
+
+// usesTLS returns whether DNS over TLS is used by default or for any domain.
+func usesTLS(config *Config) bool {
+	if config.Net == "tcp-tls" {
+		return true
+	}
+
+	for _, domainConfig := range config.Domain {
+		if domainConfig.Net == "tcp-tls" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newClients creates the client for the net and the TCP client to retry truncated responses ("auto" and "udp").
+func (dnsCli *DNSClient) newClients(netName string) (client *dns.Client, tcpClient *dns.Client) {
+	client = &dns.Client{Net: netName, TsigSecret: dnsCli.tsigSecret}
+
+	// Retry over TCP when the UDP response is truncated
+	if netName == "" || netName == NetAuto || netName == "udp" {
+		client.Net = "udp"
+		tcpClient = &dns.Client{Net: "tcp", TsigSecret: dnsCli.tsigSecret}
+	}
+
+	return
+}
+
+// newDomainResolver creates DomainResolver struct from [domain."name"].
+// The settings not specified in [domain."name"] are inherited from the top-level settings.
+func (dnsCli *DNSClient) newDomainResolver(config *Config, domainConfig *DomainConfig) (resolver *DomainResolver) {
+	netName := config.Net
+
+	if domainConfig.Net != "" {
+		netName = domainConfig.Net
+	}
+
+	resolver = &DomainResolver{}
+	resolver.Client, resolver.TCPClient = dnsCli.newClients(netName)
+	resolver.Client.Timeout = dnsCli.Client.Timeout
+
+	if domainConfig.Timeout > 0 {
+		resolver.Client.Timeout = time.Duration(domainConfig.Timeout) * time.Second
+	}
+
+	if resolver.TCPClient != nil {
+		resolver.TCPClient.Timeout = resolver.Client.Timeout
+	}
+
+	port := dnsCli.ClientConfig.Port
+
+	if netName == "tcp-tls" {
+		resolver.Client.TLSConfig = dnsCli.tlsConfig
+		port = DefaultTLSPort
+	}
+
+	nameservers := domainConfig.Nameservers
+
+	// The domain without its own nameservers uses the top-level nameservers with the port of its net
+	if len(nameservers) == 0 {
+		nameservers = config.Nameservers
+	}
+
+	resolver.Port = port
+	resolver.Nameservers = normalizeNameservers(nameservers, port)
+	return
+}
+
+// clients returns the clients of the domain.
+func (dnsCli *DNSClient) clients(domain string) (client *dns.Client, tcpClient *dns.Client) {
+	if resolver, ok := dnsCli.Resolvers[domain]; ok {
+		return resolver.Client, resolver.TCPClient
+	}
+
+	return dnsCli.Client, dnsCli.TCPClient
+}
+
+// doh returns the DNS over HTTPS client of the domain.
+func (dnsCli *DNSClient) doh(domain string) *DoHClient {
+	if resolver, ok := dnsCli.Resolvers[domain]; ok && resolver.DoH != nil {
+		return resolver.DoH
+	}
+
+	return dnsCli.DoH
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func targetHandler(target string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(req)
+
+		m.Answer = []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: target, Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 3}},
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+func TestDNSClientDigWithDomainNameservers(t *testing.T) {
+	assert := assert.New(t)
+	defaultAddr, shutdownDefault := testutils.StartDNSServer("tcp", targetHandler("default.example.com."), nil)
+	defer shutdownDefault()
+	consulAddr, shutdownConsul := testutils.StartDNSServer("tcp", targetHandler("consul.example.com."), nil)
+	defer shutdownConsul()
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com", "_svc._tcp.service.consul"},
+		ResolvConf:  "/not/exist/resolv.conf",
+		Net:         "tcp",
+		Nameservers: []string{defaultAddr},
+		Domain: map[string]DomainConfig{
+			"_svc._tcp.service.consul": DomainConfig{Nameservers: []string{consulAddr}, Timeout: 3, Edns0Size: 1232},
+		},
+	}
+
+	dnsCli, err := NewDNSClient(config)
+	assert.Equal(nil, err)
+	srvsByDomain := dnsCli.Dig()
+	assert.Equal("default.example.com.", srvsByDomain["_mysql._tcp.example.com"][0].Target)
+	assert.Equal("consul.example.com.", srvsByDomain["_svc._tcp.service.consul"][0].Target)
+
+	resolver := dnsCli.Resolvers["_svc._tcp.service.consul"]
+	assert.Equal("tcp", resolver.Client.Net)
+	assert.Equal(3*time.Second, resolver.Client.Timeout)
+	assert.Equal(uint16(1232), dnsCli.Messages["_svc._tcp.service.consul"].IsEdns0().UDPSize())
+}
+
+func TestDNSClientWithDomainNet(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &Config{
+		Domains:     []string{"_mysql._tcp.example.com", "_svc._tcp.service.consul"},
+		ResolvConf:  "/etc/resolv.conf",
+		Edns0Size:   4096,
+		Nameservers: []string{"10.0.0.2", "10.0.0.3:5353", "::1"},
+		Domain: map[string]DomainConfig{
+			"_svc._tcp.service.consul": DomainConfig{Nameservers: []string{"127.0.0.1"}, Net: "tcp-tls"},
+		},
+	}
+
+	dnsCli, err := NewDNSClient(config)
+	assert.Equal(nil, err)
+	assert.Equal([]string{"10.0.0.2:53", "10.0.0.3:5353", "[::1]:53"}, dnsCli.servers("_mysql._tcp.example.com"))
+	assert.Equal([]string{"127.0.0.1:853"}, dnsCli.servers("_svc._tcp.service.consul"))
+
+	client, tcpClient := dnsCli.clients("_mysql._tcp.example.com")
+	assert.Equal("udp", client.Net)
+	assert.Equal("tcp", tcpClient.Net)
+
+	client, tcpClient = dnsCli.clients("_svc._tcp.service.consul")
+	assert.Equal("tcp-tls", client.Net)
+	assert.NotNil(client.TLSConfig)
+	assert.Nil(tcpClient)
+	assert.Equal(uint16(4096), dnsCli.Messages["_svc._tcp.service.consul"].IsEdns0().UDPSize())
+}
+
+func TestDNSClientWithDomainNetWithoutDomainNameservers(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("nameserver 10.0.0.4\n", func(f *os.File) {
+		for _, nameservers := range [][]string{{"10.0.0.2", "10.0.0.3:5353"}, nil} {
+			// DNS over TLS for the domain under UDP
+			config := &Config{
+				Domains:     []string{"_mysql._tcp.example.com", "_svc._tcp.example.com"},
+				ResolvConf:  f.Name(),
+				Nameservers: nameservers,
+				Domain: map[string]DomainConfig{
+					"_svc._tcp.example.com": DomainConfig{Net: "tcp-tls"},
+				},
+			}
+
+			dnsCli, err := NewDNSClient(config)
+			assert.Equal(nil, err)
+
+			if nameservers != nil {
+				assert.Equal([]string{"10.0.0.2:53", "10.0.0.3:5353"}, dnsCli.servers("_mysql._tcp.example.com"))
+				assert.Equal([]string{"10.0.0.2:853", "10.0.0.3:5353"}, dnsCli.servers("_svc._tcp.example.com"))
+			} else {
+				assert.Equal([]string{"10.0.0.4:53"}, dnsCli.servers("_mysql._tcp.example.com"))
+				assert.Equal([]string{"10.0.0.4:853"}, dnsCli.servers("_svc._tcp.example.com"))
+			}
+
+			// UDP for the domain under DNS over TLS
+			config.Net = "tcp-tls"
+			config.Domain = map[string]DomainConfig{
+				"_svc._tcp.example.com": DomainConfig{Net: "udp"},
+			}
+
+			dnsCli, err = NewDNSClient(config)
+			assert.Equal(nil, err)
+
+			if nameservers != nil {
+				assert.Equal([]string{"10.0.0.2:853", "10.0.0.3:5353"}, dnsCli.servers("_mysql._tcp.example.com"))
+				assert.Equal([]string{"10.0.0.2:53", "10.0.0.3:5353"}, dnsCli.servers("_svc._tcp.example.com"))
+			} else {
+				assert.Equal([]string{"10.0.0.4:853"}, dnsCli.servers("_mysql._tcp.example.com"))
+				assert.Equal([]string{"10.0.0.4:53"}, dnsCli.servers("_svc._tcp.example.com"))
+			}
+		}
+	})
+}
+
+func TestNormalizeNameservers(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"10.0.0.2:53", "10.0.0.3:8600", "[fd00::1]:53", "[fd00::2]:8600"}, normalizeNameservers([]string{"10.0.0.2", "10.0.0.3:8600", "fd00::1", "[fd00::2]:8600"}, "53"))
+}
+
+func TestLoadClientConfigWithoutResolvConf(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := loadClientConfig(&Config{ResolvConf: "/not/exist/resolv.conf"})
+	assert.True(os.IsNotExist(err))
+
+	clientConfig, err := loadClientConfig(&Config{ResolvConf: "/not/exist/resolv.conf", Nameservers: []string{"10.0.0.2"}})
+	assert.Equal(nil, err)
+	assert.Equal("53", clientConfig.Port)
+	assert.Equal(1, clientConfig.Ndots)
+}
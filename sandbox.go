@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/gliderlabs/sigil/builtin"
+)
+
+// SandboxFuncMap is the pure string/list functions of sigil allowed in the template sandbox.
+var SandboxFuncMap = template.FuncMap{
+	"default":    builtin.Default,
+	"capitalize": builtin.Capitalize,
+	"lower":      builtin.Lower,
+	"upper":      builtin.Upper,
+	"replace":    builtin.Replace,
+	"trim":       builtin.Trim,
+	"indent":     builtin.Indent,
+	"match":      builtin.Match,
+	"pointer":    builtin.Pointer,
+	"tojson":     builtin.ToJson,
+	"toyaml":     builtin.ToYaml,
+	"uniq":       builtin.Uniq,
+	"drop":       builtin.Drop,
+	"append":     builtin.Append,
+	"seq":        builtin.Seq,
+	"join":       builtin.Join,
+	"joinkv":     builtin.JoinKv,
+	"split":      builtin.Split,
+	"splitkv":    builtin.SplitKv,
+}
+
+// SandboxDeniedFuncs is the sigil functions which access files, environment variables or external commands.
+var SandboxDeniedFuncs = []string{
+	"var",
+	"render",
+	"stdin",
+	"file",
+	"exists",
+	"dir",
+	"dirs",
+	"files",
+	"text",
+	"sh",
+	"httpget",
+	// json and yaml read the file if the argument is a path
+	"json",
+	"yaml",
+}
+
+// sandboxFuncMap returns the functions with stubs of the denied functions.
+// The stubs make the template parsable so that checkSandbox can report the denied function.
+func sandboxFuncMap() (funcMap template.FuncMap) {
+	funcMap = template.FuncMap{}
+
+	for k, v := range SandboxFuncMap {
+		funcMap[k] = v
+	}
+
+	for _, name := range SandboxDeniedFuncs {
+		funcMap[name] = func(...interface{}) (string, error) {
+			return "", fmt.Errorf("not allowed in template_sandbox mode")
+		}
+	}
+
+	return
+}
+
+// checkSandbox returns an error if the template references the denied functions.
+func checkSandbox(t *template.Template) (err error) {
+	denied := make(map[string]bool, len(SandboxDeniedFuncs))
+
+	for _, name := range SandboxDeniedFuncs {
+		denied[name] = true
+	}
+
+	for _, tt := range t.Templates() {
+		if tt.Tree == nil {
+			continue
+		}
+
+		err = walkSandbox(tt.Tree, tt.Tree.Root, denied)
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func walkSandbox(tree *parse.Tree, node parse.Node, denied map[string]bool) (err error) {
+	var children []parse.Node
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+
+		for _, c := range n.Nodes {
+			children = append(children, c)
+		}
+	case *parse.ActionNode:
+		children = append(children, n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+
+		for _, c := range n.Cmds {
+			children = append(children, c)
+		}
+	case *parse.CommandNode:
+		children = append(children, n.Args...)
+	case *parse.ChainNode:
+		children = append(children, n.Node)
+	case *parse.IfNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.RangeNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.WithNode:
+		children = append(children, n.Pipe, n.List, n.ElseList)
+	case *parse.TemplateNode:
+		children = append(children, n.Pipe)
+	case *parse.IdentifierNode:
+		if denied[n.Ident] {
+			location, _ := tree.ErrorContext(n)
+			err = fmt.Errorf("template: %s: function %q is not allowed in template_sandbox mode", location, n.Ident)
+		}
+	}
+
+	for _, c := range children {
+		err = walkSandbox(tree, c, denied)
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
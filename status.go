@@ -4,8 +4,23 @@ import (
 	"time"
 )
 
+const (
+	// ChangeReasonDNS means that the configuration file was updated by the change of SRV records.
+	ChangeReasonDNS = "dns"
+	// ChangeReasonTemplate means that the configuration file was updated by the change of the template.
+	ChangeReasonTemplate = "template"
+	// ChangeReasonNotify means that the configuration file was updated by the change of SRV records notified by DNS NOTIFY.
+	ChangeReasonNotify = "notify"
+)
+
 // Status struct has the status of srvd.
 type Status struct {
-	LastUpdate time.Time
-	Ok         bool
+	LastUpdate       time.Time
+	Ok               bool
+	LastChangeReason string                  `json:",omitempty"`
+	Domains          map[string]DomainStatus `json:",omitempty"`
+	Metrics          *Metrics                `json:",omitempty"`
+	Servers          map[string]ServerHealth `json:",omitempty"`
+	Transfer         *TransferStatus         `json:",omitempty"`
+	Browse           map[string]DomainStatus `json:",omitempty"`
 }
@@ -7,39 +7,64 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/template"
 
 	"github.com/gliderlabs/sigil"
 	_ "github.com/gliderlabs/sigil/builtin"
 	"github.com/miekg/dns"
-	_ "github.com/winebarrel/srvd/tmplfuncs"
+	"github.com/winebarrel/srvd/tmplfuncs"
 	"github.com/winebarrel/srvd/utils"
 )
 
+const (
+	// MaxIncludeDepth is the maximum nesting depth of the template includes.
+	MaxIncludeDepth = 16
+)
+
 // Template struct has template information of the configuration file to be updated.
 type Template struct {
-	Src       string
-	Dest      string
-	DestMode  os.FileMode
-	DestUID   int
-	DestGID   int
-	CheckCmd  *Command
-	ReloadCmd *Command
-	Status    *Status
-	Config    *Config
+	Src          string
+	Engine       string
+	Sandbox      bool
+	Dest         string
+	TemplateDir  string
+	DestMode     os.FileMode
+	DestUID      int
+	DestGID      int
+	CheckCmd     *Command
+	ReloadCmd    *Command
+	Status       *Status
+	Config       *Config
+	Zone         map[string][]*dns.SRV
+	Browse       map[string][]*ServiceInstance
+	partials     map[string]bool
+	includeDepth int
+	mutex        sync.Mutex
 }
 
 // NewTemplate creates Template struct.
 func NewTemplate(config *Config, status *Status) (tmpl *Template, err error) {
 	tmpl = &Template{
-		Src:       config.Src,
-		Dest:      config.Dest,
-		DestMode:  0644,
-		DestUID:   os.Getuid(),
-		DestGID:   os.Getgid(),
-		ReloadCmd: NewCommand(config.ReloadCmd, config.Timeout),
-		Status:    status,
-		Config:    config,
+		Src:         config.Src,
+		Engine:      config.Engine,
+		Sandbox:     config.TemplateSandbox,
+		Dest:        config.Dest,
+		TemplateDir: config.TemplateDir,
+		DestMode:    0644,
+		DestUID:     os.Getuid(),
+		DestGID:     os.Getgid(),
+		ReloadCmd:   NewCommand(config.ReloadCmd, config.Timeout),
+		Status:      status,
+		Config:      config,
+	}
+
+	if tmpl.TemplateDir == "" {
+		tmpl.TemplateDir = filepath.Dir(tmpl.Src)
 	}
 
 	if config.CheckCmd != "" && !config.Nocheck {
@@ -62,6 +87,136 @@ func NewTemplate(config *Config, status *Status) (tmpl *Template, err error) {
 	return
 }
 
+// WatchFiles returns the template files to be watched.
+// It includes the partials included in the last evaluation.
+func (tmpl *Template) WatchFiles() (files []string) {
+	tmpl.mutex.Lock()
+	defer tmpl.mutex.Unlock()
+	files = []string{tmpl.Src}
+
+	for path := range tmpl.partials {
+		files = append(files, path)
+	}
+
+	return
+}
+
+// include renders the partial template with the given data.
+// The relative path of the partial is resolved from the template directory.
+func (tmpl *Template) include(name string, data ...interface{}) (out string, err error) {
+	if tmpl.includeDepth >= MaxIncludeDepth {
+		err = fmt.Errorf("include %s: nested too deeply", name)
+		return
+	}
+
+	path := name
+
+	if tmpl.Sandbox {
+		if rel := filepath.Clean(name); filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+			err = fmt.Errorf("include %s: the path outside the template directory is not allowed in template_sandbox mode", name)
+			return
+		}
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(tmpl.TemplateDir, name)
+	}
+
+	input, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	tmpl.mutex.Lock()
+	tmpl.partials[path] = true
+	tmpl.mutex.Unlock()
+
+	var dot interface{}
+
+	if len(data) > 0 {
+		dot = data[0]
+	}
+
+	tmpl.includeDepth++
+	defer func() { tmpl.includeDepth-- }()
+	var buf bytes.Buffer
+
+	if tmpl.Engine == EngineGoTemplate || tmpl.Sandbox {
+		buf, err = tmpl.executeGoTemplate(input, dot, name)
+	} else {
+		// Wrap the partial in "define" on the same line so that errors report the line in the partial file
+		define := strconv.Quote("include " + name)
+		wrapped := []byte("{{ define " + define + " }}")
+		wrapped = append(wrapped, input...)
+		wrapped = append(wrapped, []byte("{{ end }}{{ template "+define+" .data }}")...)
+		buf, err = sigil.Execute(wrapped, map[string]interface{}{"data": dot}, name)
+	}
+
+	if err != nil {
+		return
+	}
+
+	out = buf.String()
+	return
+}
+
+// executeGoTemplate executes the template using text/template.
+// It is used for the gotemplate engine and the template sandbox.
+func (tmpl *Template) executeGoTemplate(input []byte, data interface{}, name string) (buf bytes.Buffer, err error) {
+	funcMap := template.FuncMap{}
+
+	if tmpl.Sandbox {
+		funcMap = sandboxFuncMap()
+	}
+
+	for k, v := range tmplfuncs.FuncMap {
+		funcMap[k] = v
+	}
+
+	funcMap["include"] = tmpl.include
+	t := template.New(name).Funcs(funcMap)
+
+	if tmpl.Engine == EngineGoTemplate {
+		t = t.Option("missingkey=error")
+	}
+
+	t, err = t.Parse(string(input))
+
+	if err != nil {
+		return
+	}
+
+	if tmpl.Sandbox {
+		err = checkSandbox(t)
+
+		if err != nil {
+			return
+		}
+	}
+
+	err = t.Execute(&buf, data)
+	return
+}
+
+// sigilVarsPrelude returns the variable declarations which sigil adds to the template (e.g. "$domains").
+// It is written on the first line so as not to shift the line numbers.
+func sigilVarsPrelude(vars map[string]interface{}) (prelude []byte) {
+	keys := make([]string, 0, len(vars))
+
+	for k := range vars {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		prelude = append(prelude, []byte(fmt.Sprintf("{{ $%s := .%s }}", k, k))...)
+	}
+
+	return
+}
+
 func (tmpl *Template) evalute(srvsByDomain map[string][]*dns.SRV) (pbuf *bytes.Buffer, err error) {
 	input, err := ioutil.ReadFile(tmpl.Src)
 
@@ -71,10 +226,40 @@ func (tmpl *Template) evalute(srvsByDomain map[string][]*dns.SRV) (pbuf *bytes.B
 
 	vars := map[string]interface{}{
 		"domains": srvsByDomain,
+		"zone":    map[string][]*dns.SRV{},
+		"browse":  map[string][]*ServiceInstance{},
+		"vars":    map[string]interface{}{},
+	}
+
+	if tmpl.Zone != nil {
+		vars["zone"] = tmpl.Zone
+	}
+
+	if tmpl.Browse != nil {
+		vars["browse"] = tmpl.Browse
 	}
 
+	if tmpl.Config != nil && tmpl.Config.Vars != nil {
+		vars["vars"] = tmpl.Config.Vars
+	}
+
+	tmpl.mutex.Lock()
+	tmpl.partials = map[string]bool{}
+	tmpl.mutex.Unlock()
+
 	name := filepath.Base(tmpl.Src)
-	buf, err := sigil.Execute(input, vars, name)
+	var buf bytes.Buffer
+
+	if tmpl.Engine == EngineGoTemplate {
+		buf, err = tmpl.executeGoTemplate(input, vars, name)
+	} else if tmpl.Sandbox {
+		// Evaluate the sigil template using text/template without the builtin functions of sigil
+		buf, err = tmpl.executeGoTemplate(append(sigilVarsPrelude(vars), input...), vars, name)
+	} else {
+		// Override the "include" of sigil to resolve the partials from the template directory
+		sigil.Register(template.FuncMap{"include": tmpl.include})
+		buf, err = sigil.Execute(input, vars, name)
+	}
 
 	if err != nil {
 		return
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -29,6 +30,233 @@ func TestTemplateEvalute(t *testing.T) {
 	})
 }
 
+func TestTemplateEvaluteWithVars(t *testing.T) {
+	assert := assert.New(t)
+	tmpl := &Template{Config: &Config{Vars: map[string]interface{}{"port": int64(3306)}}}
+	srvsByDomain := map[string][]*dns.SRV{}
+	tmplSrc := `bind *:{{ .vars.port }}`
+
+	testutils.TempFile(tmplSrc, func(f *os.File) {
+		tmpl.Src = f.Name()
+		buf, _ := tmpl.evalute(srvsByDomain)
+		assert.Equal("bind *:3306", buf.String())
+	})
+}
+
+func TestTemplateEvaluteWithGoTemplate(t *testing.T) {
+	assert := assert.New(t)
+	tmpl := &Template{Engine: EngineGoTemplate}
+
+	srvsByDomain := map[string][]*dns.SRV{
+		"_mysql._tcp.example.com": []*dns.SRV{&dns.SRV{Target: "server.example.com."}},
+	}
+
+	tmplSrc := `{{ range fetchsrvs .domains "_mysql._tcp.example.com" }}{{ .Target }}{{ end }}`
+
+	testutils.TempFile(tmplSrc, func(f *os.File) {
+		tmpl.Src = f.Name()
+		buf, err := tmpl.evalute(srvsByDomain)
+		assert.Equal(nil, err)
+		assert.Equal("server.example.com.", buf.String())
+	})
+}
+
+func TestTemplateEvaluteWithZone(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, engine := range []string{EngineSigil, EngineGoTemplate} {
+		tmpl := &Template{
+			Engine: engine,
+			Zone: map[string][]*dns.SRV{
+				"_http._tcp.svc.example.com": []*dns.SRV{&dns.SRV{Target: "web1.example.com.", Port: 80}},
+			},
+		}
+
+		tmplSrc := `{{ range $name, $srvs := .zone }}{{ $name }}={{ range $srvs }}{{ .Target }}:{{ .Port }}{{ end }}{{ end }}`
+
+		testutils.TempFile(tmplSrc, func(f *os.File) {
+			tmpl.Src = f.Name()
+			buf, err := tmpl.evalute(map[string][]*dns.SRV{})
+			assert.Equal(nil, err)
+			assert.Equal("_http._tcp.svc.example.com=web1.example.com.:80", buf.String())
+		})
+	}
+}
+
+func TestTemplateEvaluteWithBrowse(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpl := &Template{
+		Engine: EngineGoTemplate,
+		Browse: map[string][]*ServiceInstance{
+			"_http._tcp.example.com": []*ServiceInstance{&ServiceInstance{Name: "Web 1", Target: "host1.example.com.", Port: 8080, TXT: map[string]string{"path": "/api"}}},
+		},
+	}
+
+	tmplSrc := `{{ range index .browse "_http._tcp.example.com" }}{{ .Name }}={{ .Target }}:{{ .Port }}{{ .TXT.path }}{{ end }}`
+
+	testutils.TempFile(tmplSrc, func(f *os.File) {
+		tmpl.Src = f.Name()
+		buf, err := tmpl.evalute(map[string][]*dns.SRV{})
+		assert.Equal(nil, err)
+		assert.Equal("Web 1=host1.example.com.:8080/api", buf.String())
+	})
+}
+
+func TestTemplateEvaluteWithGoTemplateMissingKey(t *testing.T) {
+	assert := assert.New(t)
+	tmpl := &Template{Engine: EngineGoTemplate}
+	srvsByDomain := map[string][]*dns.SRV{}
+
+	testutils.TempFile(`{{ .vars.port }}`, func(f *os.File) {
+		tmpl.Src = f.Name()
+		_, err := tmpl.evalute(srvsByDomain)
+		assert.Contains(err.Error(), `map has no entry for key "port"`)
+	})
+}
+
+func TestTemplateEvaluteWithGoTemplateSigilBuiltin(t *testing.T) {
+	assert := assert.New(t)
+	tmpl := &Template{Engine: EngineGoTemplate}
+	srvsByDomain := map[string][]*dns.SRV{}
+
+	testutils.TempFile(`{{ sh "id" }}`, func(f *os.File) {
+		tmpl.Src = f.Name()
+		_, err := tmpl.evalute(srvsByDomain)
+		assert.Contains(err.Error(), `function "sh" not defined`)
+	})
+}
+
+func TestTemplateEvaluteWithGoTemplateInclude(t *testing.T) {
+	assert := assert.New(t)
+
+	srvsByDomain := map[string][]*dns.SRV{
+		"_mysql._tcp.example.com": []*dns.SRV{&dns.SRV{Target: "server.example.com.", Port: 3306}},
+	}
+
+	testutils.TempFile(`{{ range . }}server {{ .Target }}:{{ .Port }}{{ end }}`, func(partial *os.File) {
+		tmplSrc := `backend {{ include "` + filepath.Base(partial.Name()) + `" (index .domains "_mysql._tcp.example.com") }}`
+
+		testutils.TempFile(tmplSrc, func(f *os.File) {
+			tmpl := &Template{Src: f.Name(), Engine: EngineGoTemplate, TemplateDir: filepath.Dir(partial.Name())}
+			buf, err := tmpl.evalute(srvsByDomain)
+			assert.Equal(nil, err)
+			assert.Equal("backend server server.example.com.:3306", buf.String())
+		})
+	})
+}
+
+func TestTemplateEvaluteWithSandbox(t *testing.T) {
+	assert := assert.New(t)
+	tmpl := &Template{Sandbox: true}
+
+	srvsByDomain := map[string][]*dns.SRV{
+		"_mysql._tcp.example.com": []*dns.SRV{&dns.SRV{Target: "server.example.com."}},
+	}
+
+	tmplSrc := `{{ range fetchsrvs $domains "_mysql._tcp.example.com" }}{{ .Target | upper }}{{ end }}`
+
+	testutils.TempFile(tmplSrc, func(f *os.File) {
+		tmpl.Src = f.Name()
+		buf, err := tmpl.evalute(srvsByDomain)
+		assert.Equal(nil, err)
+		assert.Equal("SERVER.EXAMPLE.COM.", buf.String())
+	})
+}
+
+func TestTemplateEvaluteWithSandboxDeniedFunc(t *testing.T) {
+	assert := assert.New(t)
+	srvsByDomain := map[string][]*dns.SRV{}
+
+	for _, engine := range []string{EngineSigil, EngineGoTemplate} {
+		tmpl := &Template{Sandbox: true, Engine: engine}
+
+		testutils.TempFile("backend nodes\n{{ if false }}{{ file \"/etc/shadow\" }}{{ end }}", func(f *os.File) {
+			tmpl.Src = f.Name()
+			_, err := tmpl.evalute(srvsByDomain)
+			assert.Equal("template: "+filepath.Base(f.Name())+":2:17: function \"file\" is not allowed in template_sandbox mode", err.Error())
+		})
+	}
+}
+
+func TestTemplateEvaluteWithSandboxDeniedJSON(t *testing.T) {
+	assert := assert.New(t)
+	srvsByDomain := map[string][]*dns.SRV{}
+
+	for _, engine := range []string{EngineSigil, EngineGoTemplate} {
+		tmpl := &Template{Sandbox: true, Engine: engine}
+
+		testutils.TempFile("backend nodes\n{{ json \"/etc/passwd\" }}", func(f *os.File) {
+			tmpl.Src = f.Name()
+			_, err := tmpl.evalute(srvsByDomain)
+			assert.Equal("template: "+filepath.Base(f.Name())+":2:3: function \"json\" is not allowed in template_sandbox mode", err.Error())
+		})
+	}
+}
+
+func TestTemplateEvaluteWithSandboxIncludeOutside(t *testing.T) {
+	assert := assert.New(t)
+	srvsByDomain := map[string][]*dns.SRV{}
+
+	testutils.TempFile(`{{ include "../etc/passwd" }}`, func(f *os.File) {
+		tmpl := &Template{Src: f.Name(), TemplateDir: filepath.Dir(f.Name()), Sandbox: true}
+		_, err := tmpl.evalute(srvsByDomain)
+		assert.Contains(err.Error(), "include ../etc/passwd: the path outside the template directory is not allowed in template_sandbox mode")
+	})
+}
+
+func TestTemplateEvaluteWithInclude(t *testing.T) {
+	assert := assert.New(t)
+
+	srvsByDomain := map[string][]*dns.SRV{
+		"_mysql._tcp.example.com": []*dns.SRV{&dns.SRV{Target: "server.example.com.", Port: 3306}},
+	}
+
+	partialSrc := `{{ range . }}server {{ .Target }}:{{ .Port }}{{ end }}`
+
+	testutils.TempFile(partialSrc, func(partial *os.File) {
+		tmplSrc := `backend {{ include "` + filepath.Base(partial.Name()) + `" (index .domains "_mysql._tcp.example.com") }}`
+
+		testutils.TempFile(tmplSrc, func(f *os.File) {
+			tmpl := &Template{Src: f.Name(), TemplateDir: filepath.Dir(partial.Name())}
+			buf, err := tmpl.evalute(srvsByDomain)
+			assert.Equal(nil, err)
+			assert.Equal("backend server server.example.com.:3306", buf.String())
+			assert.Equal([]string{f.Name(), partial.Name()}, tmpl.WatchFiles())
+		})
+	})
+}
+
+func TestTemplateEvaluteWithIncludeFailed(t *testing.T) {
+	assert := assert.New(t)
+	srvsByDomain := map[string][]*dns.SRV{}
+	partialSrc := "backend nodes\n  {{ .Foo.Bar }}"
+
+	testutils.TempFile(partialSrc, func(partial *os.File) {
+		name := filepath.Base(partial.Name())
+		tmplSrc := `{{ include "` + name + `" 1 }}`
+
+		testutils.TempFile(tmplSrc, func(f *os.File) {
+			tmpl := &Template{Src: f.Name(), TemplateDir: filepath.Dir(partial.Name())}
+			_, err := tmpl.evalute(srvsByDomain)
+			assert.Contains(err.Error(), "template: "+name+":2:")
+		})
+	})
+}
+
+func TestTemplateEvaluteWithRecursiveInclude(t *testing.T) {
+	assert := assert.New(t)
+	srvsByDomain := map[string][]*dns.SRV{}
+
+	testutils.TempFile("", func(f *os.File) {
+		name := filepath.Base(f.Name())
+		f.WriteString(`{{ include "` + name + `" . }}`)
+		tmpl := &Template{Src: f.Name(), TemplateDir: filepath.Dir(f.Name())}
+		_, err := tmpl.evalute(srvsByDomain)
+		assert.Contains(err.Error(), "include "+name+": nested too deeply")
+	})
+}
+
 func TestTemplateCreateTempDest(t *testing.T) {
 	assert := assert.New(t)
 
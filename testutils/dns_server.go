@@ -0,0 +1,69 @@
+package testutils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// StartDNSServer starts a local DNS server ("udp", "tcp" or "tcp-tls") and returns its address and shutdown function.
+func StartDNSServer(network string, handler dns.Handler, tlsConfig *tls.Config) (addr string, shutdown func()) {
+	return StartCustomDNSServer(network, &dns.Server{Handler: handler}, tlsConfig)
+}
+
+// StartCustomDNSServer starts the given DNS server (e.g. with TsigSecret) on a local address.
+func StartCustomDNSServer(network string, server *dns.Server, tlsConfig *tls.Config) (addr string, shutdown func()) {
+	started := make(chan bool)
+	server.NotifyStartedFunc = func() { close(started) }
+
+	switch network {
+	case "udp":
+		pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		server.PacketConn = pc
+		addr = pc.LocalAddr().String()
+	case "tcp-tls":
+		l, _ := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+		server.Listener = l
+		addr = l.Addr().String()
+	default:
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		server.Listener = l
+		addr = l.Addr().String()
+	}
+
+	go server.ActivateAndServe()
+	<-started
+	shutdown = func() { server.Shutdown() }
+	return
+}
+
+// SelfSignedCert creates a self-signed certificate for "localhost" and 127.0.0.1 and returns it in PEM format.
+func SelfSignedCert() (certPEM []byte, keyPEM []byte) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return
+}
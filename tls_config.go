@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	// DefaultTLSPort is the default port of DNS over TLS.
+	DefaultTLSPort = "853"
+)
+
+// TLSConfig struct has the TLS settings to connect to the resolvers.
+type TLSConfig struct {
+	ServerName         string `toml:"server_name"`
+	CAFile             string `toml:"ca_file"`
+	CertFile           string `toml:"cert_file"`
+	KeyFile            string `toml:"key_file"`
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"`
+}
+
+// NewTLSConfig creates tls.Config from TLSConfig struct.
+func NewTLSConfig(config *TLSConfig) (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CAFile != "" {
+		ca, e := ioutil.ReadFile(config.CAFile)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			err = fmt.Errorf("No certificate found in %s", config.CAFile)
+			return
+		}
+	}
+
+	if config.CertFile != "" {
+		cert, e := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return
+}
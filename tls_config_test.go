@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	assert := assert.New(t)
+	certPEM, keyPEM := testutils.SelfSignedCert()
+
+	testutils.TempFile(string(certPEM), func(certFile *os.File) {
+		testutils.TempFile(string(keyPEM), func(keyFile *os.File) {
+			config := &TLSConfig{
+				ServerName: "dns.example.com",
+				CAFile:     certFile.Name(),
+				CertFile:   certFile.Name(),
+				KeyFile:    keyFile.Name(),
+			}
+
+			tlsConfig, err := NewTLSConfig(config)
+			assert.Equal(nil, err)
+			assert.Equal("dns.example.com", tlsConfig.ServerName)
+			assert.Equal(false, tlsConfig.InsecureSkipVerify)
+			assert.NotNil(tlsConfig.RootCAs)
+			assert.Equal(1, len(tlsConfig.Certificates))
+		})
+	})
+}
+
+func TestNewTLSConfigWithInvalidCA(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("invalid", func(caFile *os.File) {
+		_, err := NewTLSConfig(&TLSConfig{CAFile: caFile.Name()})
+		assert.Equal("No certificate found in "+caFile.Name(), err.Error())
+	})
+}
@@ -1,10 +1,13 @@
 package tmplfuncs
 
 import (
+	"crypto/md5"
+	"encoding/binary"
 	"fmt"
 	"math/rand"
 	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -13,20 +16,36 @@ import (
 	"github.com/miekg/dns"
 )
 
+// FuncMap is the template functions provided by srvd.
+var FuncMap = template.FuncMap{
+	"interfaces":      net.Interfaces,
+	"ifaddrs":         net.InterfaceAddrs,
+	"ifbyname":        net.InterfaceByName,
+	"hostname":        os.Hostname,
+	"ipv4byif":        ipv4ByInterface,
+	"ipv4sbyif":       ipv4sByInterface,
+	"ipv4toi":         ipv4ToI,
+	"rotatesrvs":      rotateSRVs,
+	"fetchsrvs":       fetchSRVs,
+	"shufflesrvs":     shuffleSRVs,
+	"hextoi":          hexToI,
+	"weightedsrvs":    weightedSRVs,
+	"topweightedsrvs": topWeightedSRVs,
+	"groupbypriority": groupByPriority,
+	"primarysrvs":     primarySRVs,
+	"isprimary":       isPrimary,
+	"subsetsrvs":      subsetSRVs,
+	"trimdot":         trimDot,
+}
+
+// PriorityGroup struct has SRV records of the same priority.
+type PriorityGroup struct {
+	Priority uint16
+	SRVs     []*dns.SRV
+}
+
 func init() {
-	sigil.Register(template.FuncMap{
-		"interfaces":  net.Interfaces,
-		"ifaddrs":     net.InterfaceAddrs,
-		"ifbyname":    net.InterfaceByName,
-		"hostname":    os.Hostname,
-		"ipv4byif":    ipv4ByInterface,
-		"ipv4sbyif":   ipv4sByInterface,
-		"ipv4toi":     ipv4ToI,
-		"rotatesrvs":  rotateSRVs,
-		"fetchsrvs":   fetchSRVs,
-		"shufflesrvs": shuffleSRVs,
-		"hextoi":      hexToI,
-	})
+	sigil.Register(FuncMap)
 }
 
 func ipv4sByInterface() (ipv4sByIf map[string][]string, err error) {
@@ -136,3 +155,182 @@ func hexToI(hex string) (i int64, err error) {
 	i, err = strconv.ParseInt(hex, 16, 64)
 	return
 }
+
+// weightedSRVs orders SRV records according to the target selection algorithm of RFC 2782:
+// lowest priority first, weighted random within each priority.
+func weightedSRVs(seed int64, ary []*dns.SRV) []*dns.SRV {
+	n := len(ary)
+	newAry := make([]*dns.SRV, 0, n)
+	sorted := make([]*dns.SRV, n)
+	copy(sorted, ary)
+
+	// Records with weight 0 are placed at the beginning of each priority group (RFC 2782)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		} else if (sorted[i].Weight == 0) != (sorted[j].Weight == 0) {
+			return sorted[i].Weight == 0
+		} else if sorted[i].Target != sorted[j].Target {
+			return sorted[i].Target < sorted[j].Target
+		}
+
+		return sorted[i].Port < sorted[j].Port
+	})
+
+	src := rand.NewSource(seed)
+	rnd := rand.New(src)
+
+	for start := 0; start < n; {
+		end := start
+
+		for end < n && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+
+		group := sorted[start:end]
+
+		for len(group) > 0 {
+			sum := 0
+
+			for _, srv := range group {
+				sum += int(srv.Weight)
+			}
+
+			r := rnd.Intn(sum + 1)
+			i := 0
+
+			for running := int(group[0].Weight); running < r; running += int(group[i].Weight) {
+				i++
+			}
+
+			newAry = append(newAry, group[i])
+			group = append(group[:i:i], group[i+1:]...)
+		}
+
+		start = end
+	}
+
+	return newAry
+}
+
+// topWeightedSRVs returns the first n SRV records ordered by weightedSRVs.
+func topWeightedSRVs(seed int64, n int, ary []*dns.SRV) []*dns.SRV {
+	newAry := weightedSRVs(seed, ary)
+
+	if n < 0 {
+		n = 0
+	}
+
+	if n < len(newAry) {
+		newAry = newAry[:n]
+	}
+
+	return newAry
+}
+
+// groupByPriority groups SRV records by priority in ascending order of priority.
+func groupByPriority(ary []*dns.SRV) (groups []*PriorityGroup) {
+	groups = []*PriorityGroup{}
+	groupByPriority := map[uint16]*PriorityGroup{}
+
+	for _, srv := range ary {
+		group, ok := groupByPriority[srv.Priority]
+
+		if !ok {
+			group = &PriorityGroup{Priority: srv.Priority, SRVs: []*dns.SRV{}}
+			groupByPriority[srv.Priority] = group
+			groups = append(groups, group)
+		}
+
+		group.SRVs = append(group.SRVs, srv)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Priority < groups[j].Priority
+	})
+
+	return
+}
+
+// primarySRVs returns SRV records in the lowest priority group.
+func primarySRVs(ary []*dns.SRV) []*dns.SRV {
+	groups := groupByPriority(ary)
+
+	if len(groups) == 0 {
+		return []*dns.SRV{}
+	}
+
+	return groups[0].SRVs
+}
+
+// isPrimary returns whether the SRV record belongs to the lowest priority group.
+func isPrimary(srv *dns.SRV, ary []*dns.SRV) bool {
+	if srv == nil || len(ary) == 0 {
+		return false
+	}
+
+	lowest := ary[0].Priority
+
+	for _, s := range ary[1:] {
+		if s.Priority < lowest {
+			lowest = s.Priority
+		}
+	}
+
+	return srv.Priority == lowest
+}
+
+// rendezvousScore returns the score of the SRV record for the key (Highest Random Weight).
+func rendezvousScore(key string, srv *dns.SRV) uint64 {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s\x00%s\x00%d", key, srv.Target, srv.Port)))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// subsetSRVs returns a stable subset of n SRV records for the key using rendezvous hashing.
+// Records are taken from the lowest priority group first. If key is empty, the hostname is used.
+func subsetSRVs(key string, n int, ary []*dns.SRV) (subset []*dns.SRV, err error) {
+	if key == "" {
+		key, err = os.Hostname()
+
+		if err != nil {
+			return
+		}
+	}
+
+	subset = []*dns.SRV{}
+
+	for _, group := range groupByPriority(ary) {
+		if len(subset) >= n {
+			break
+		}
+
+		srvs := make([]*dns.SRV, len(group.SRVs))
+		copy(srvs, group.SRVs)
+		scores := make(map[*dns.SRV]uint64, len(srvs))
+
+		for _, srv := range srvs {
+			scores[srv] = rendezvousScore(key, srv)
+		}
+
+		sort.SliceStable(srvs, func(i, j int) bool {
+			return scores[srvs[i]] > scores[srvs[j]]
+		})
+
+		if rest := n - len(subset); rest < len(srvs) {
+			srvs = srvs[:rest]
+		}
+
+		subset = append(subset, srvs...)
+	}
+
+	return
+}
+
+// trimDot removes the trailing dot of the domain name (e.g. "db1.example.com." -> "db1.example.com").
+func trimDot(name string) string {
+	if name == "." {
+		return name
+	}
+
+	return strings.TrimSuffix(name, ".")
+}
@@ -1,6 +1,7 @@
 package tmplfuncs
 
 import (
+	"fmt"
 	"net"
 	"testing"
 
@@ -157,3 +158,203 @@ func TestTemplateHexToI(t *testing.T) {
 	assert.Equal(actual2, int64(9223372036854775807))
 	assert.NotEqual(err2, nil)
 }
+
+func TestTemplateFuncWeightedSRVs(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 20, Weight: 10, Target: "5"},
+		&dns.SRV{Priority: 10, Weight: 10, Target: "1"},
+		&dns.SRV{Priority: 10, Weight: 20, Target: "2"},
+		&dns.SRV{Priority: 10, Weight: 0, Target: "3"},
+		&dns.SRV{Priority: 10, Weight: 70, Target: "4"},
+		&dns.SRV{Priority: 20, Weight: 0, Target: "6"},
+	}
+
+	actual1 := weightedSRVs(1, ary)
+	actual2 := weightedSRVs(1, ary)
+	assert.Equal(actual1, actual2)
+	assert.Equal(6, len(actual1))
+
+	for _, srv := range actual1[:4] {
+		assert.Equal(uint16(10), srv.Priority)
+	}
+
+	for _, srv := range actual1[4:] {
+		assert.Equal(uint16(20), srv.Priority)
+	}
+
+	assert.Equal(ary[0].Target, "5")
+	assert.Equal(ary[1].Target, "1")
+}
+
+func TestTemplateFuncWeightedSRVsDistribution(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 10, Weight: 10, Target: "1"},
+		&dns.SRV{Priority: 10, Weight: 90, Target: "2"},
+		&dns.SRV{Priority: 10, Weight: 0, Target: "3"},
+	}
+
+	firsts := map[string]int{}
+
+	for seed := int64(0); seed < 10000; seed++ {
+		firsts[weightedSRVs(seed, ary)[0].Target]++
+	}
+
+	// weight 0 has a very small chance of being selected (RFC 2782)
+	assert.InDelta(990, firsts["1"], 200)
+	assert.InDelta(8911, firsts["2"], 200)
+	assert.InDelta(99, firsts["3"], 50)
+}
+
+func TestTemplateFuncTopWeightedSRVs(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 10, Weight: 10, Target: "1"},
+		&dns.SRV{Priority: 10, Weight: 20, Target: "2"},
+		&dns.SRV{Priority: 20, Weight: 30, Target: "3"},
+	}
+
+	assert.Equal(weightedSRVs(3, ary)[:2], topWeightedSRVs(3, 2, ary))
+	assert.Equal(weightedSRVs(3, ary), topWeightedSRVs(3, 5, ary))
+	assert.Equal([]*dns.SRV{}, topWeightedSRVs(3, 0, ary))
+}
+
+func TestTemplateFuncGroupByPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 20, Target: "3"},
+		&dns.SRV{Priority: 10, Target: "1"},
+		&dns.SRV{Priority: 30, Target: "4"},
+		&dns.SRV{Priority: 10, Target: "2"},
+	}
+
+	assert.Equal([]*PriorityGroup{
+		&PriorityGroup{Priority: 10, SRVs: []*dns.SRV{ary[1], ary[3]}},
+		&PriorityGroup{Priority: 20, SRVs: []*dns.SRV{ary[0]}},
+		&PriorityGroup{Priority: 30, SRVs: []*dns.SRV{ary[2]}},
+	}, groupByPriority(ary))
+
+	assert.Equal([]*PriorityGroup{}, groupByPriority([]*dns.SRV{}))
+}
+
+func TestTemplateFuncPrimarySRVs(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 20, Target: "3"},
+		&dns.SRV{Priority: 10, Target: "1"},
+		&dns.SRV{Priority: 10, Target: "2"},
+	}
+
+	assert.Equal([]*dns.SRV{ary[1], ary[2]}, primarySRVs(ary))
+	assert.Equal([]*dns.SRV{}, primarySRVs([]*dns.SRV{}))
+}
+
+func TestTemplateFuncIsPrimary(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 20, Target: "3"},
+		&dns.SRV{Priority: 10, Target: "1"},
+		&dns.SRV{Priority: 10, Target: "2"},
+	}
+
+	assert.Equal(false, isPrimary(ary[0], ary))
+	assert.Equal(true, isPrimary(ary[1], ary))
+	assert.Equal(true, isPrimary(ary[2], ary))
+	assert.Equal(false, isPrimary(ary[0], []*dns.SRV{}))
+}
+
+func TestTemplateFuncSubsetSRVs(t *testing.T) {
+	assert := assert.New(t)
+	ary := []*dns.SRV{}
+
+	for i := 0; i < 30; i++ {
+		ary = append(ary, &dns.SRV{Priority: 10, Target: fmt.Sprintf("server%d.example.com.", i), Port: 80})
+	}
+
+	subset1, err := subsetSRVs("client1", 5, ary)
+	assert.Equal(nil, err)
+	assert.Equal(5, len(subset1))
+	subset2, _ := subsetSRVs("client1", 5, ary)
+	assert.Equal(subset1, subset2)
+	subset3, _ := subsetSRVs("client2", 5, ary)
+	assert.NotEqual(subset1, subset3)
+
+	// Removing a target not in the subset does not change the subset
+	inSubset := map[*dns.SRV]bool{}
+
+	for _, srv := range subset1 {
+		inSubset[srv] = true
+	}
+
+	rest := []*dns.SRV{}
+	removed := false
+
+	for _, srv := range ary {
+		if !inSubset[srv] && !removed {
+			removed = true
+			continue
+		}
+
+		rest = append(rest, srv)
+	}
+
+	subset4, _ := subsetSRVs("client1", 5, rest)
+	assert.Equal(subset1, subset4)
+}
+
+func TestTemplateFuncSubsetSRVsBalance(t *testing.T) {
+	assert := assert.New(t)
+	ary := []*dns.SRV{}
+
+	for i := 0; i < 10; i++ {
+		ary = append(ary, &dns.SRV{Priority: 10, Target: fmt.Sprintf("server%d.example.com.", i), Port: 80})
+	}
+
+	counts := map[string]int{}
+
+	for i := 0; i < 1000; i++ {
+		subset, _ := subsetSRVs(fmt.Sprintf("client%d", i), 3, ary)
+
+		for _, srv := range subset {
+			counts[srv.Target]++
+		}
+	}
+
+	for _, srv := range ary {
+		assert.InDelta(300, counts[srv.Target], 60)
+	}
+}
+
+func TestTemplateFuncSubsetSRVsPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	ary := []*dns.SRV{
+		&dns.SRV{Priority: 20, Target: "3"},
+		&dns.SRV{Priority: 10, Target: "1"},
+		&dns.SRV{Priority: 10, Target: "2"},
+		&dns.SRV{Priority: 20, Target: "4"},
+	}
+
+	subset, _ := subsetSRVs("client1", 3, ary)
+	assert.Equal(3, len(subset))
+	assert.Equal(uint16(10), subset[0].Priority)
+	assert.Equal(uint16(10), subset[1].Priority)
+	assert.Equal(uint16(20), subset[2].Priority)
+
+	subset, _ = subsetSRVs("client1", 10, ary)
+	assert.Equal(4, len(subset))
+}
+
+func TestTemplateFuncTrimDot(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("db1.example.com", trimDot("db1.example.com."))
+	assert.Equal("db1.example.com", trimDot("db1.example.com"))
+	assert.Equal(".", trimDot("."))
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultTransferTimeout is the timeout of the zone transfer if dns_timeout is not set.
+	DefaultTransferTimeout = 2 * time.Second
+)
+
+// TransferConfig struct has the settings of the zone transfer ([transfer]).
+type TransferConfig struct {
+	Zone    string
+	Primary string
+	Include []string
+	Exclude []string
+}
+
+// TransferStatus struct has the result of the last zone transfer.
+type TransferStatus struct {
+	Zone         string
+	Serial       uint32
+	Records      int
+	LastTransfer time.Time `json:",omitempty"`
+	NextTransfer time.Time `json:",omitempty"`
+	Error        string    `json:",omitempty"`
+}
+
+// validateTransferConfig validates [transfer] table and normalizes the zone and the primary.
+func validateTransferConfig(config *TransferConfig) (err error) {
+	if config.Zone == "" {
+		if config.Primary != "" {
+			err = fmt.Errorf("transfer.zone is required")
+		}
+
+		return
+	}
+
+	if config.Primary == "" {
+		err = fmt.Errorf("transfer.primary is required")
+		return
+	}
+
+	config.Zone = dns.Fqdn(strings.ToLower(config.Zone))
+	config.Primary = normalizeNameservers([]string{config.Primary}, "53")[0]
+
+	for _, pattern := range append(config.Include, config.Exclude...) {
+		if _, e := path.Match(pattern, ""); e != nil {
+			err = fmt.Errorf("Invalid transfer pattern: %s", pattern)
+			return
+		}
+	}
+
+	return
+}
+
+// ZoneTransfer struct has the SRV records of the zone transferred by AXFR and updated by IXFR.
+type ZoneTransfer struct {
+	Zone       string
+	Primary    string
+	Include    []string
+	Exclude    []string
+	Timeout    time.Duration
+	Interval   time.Duration
+	BackoffMax time.Duration
+	TSIGName   string
+	TSIGAlgo   string
+	Serial     uint32
+	RefreshAt  time.Time
+	status     TransferStatus
+	refresh    time.Duration
+	retry      time.Duration
+	failures   uint64
+	records    map[string]*dns.SRV
+	tsigSecret map[string]string
+	mutex      sync.Mutex
+}
+
+// NewZoneTransfer creates ZoneTransfer struct.
+// The zone is transferred with the TSIG key of the queries if it is configured.
+func NewZoneTransfer(config *Config) (transfer *ZoneTransfer) {
+	transfer = &ZoneTransfer{
+		Zone:     config.Transfer.Zone,
+		Primary:  config.Transfer.Primary,
+		Include:  config.Transfer.Include,
+		Exclude:  config.Transfer.Exclude,
+		Timeout:  time.Duration(config.DNSTimeout) * time.Second,
+		Interval: time.Duration(config.Interval) * time.Second,
+		status:   TransferStatus{Zone: config.Transfer.Zone},
+	}
+
+	if !config.DisableBackoff {
+		transfer.BackoffMax = time.Duration(config.BackoffMax) * time.Second
+	}
+
+	if transfer.Timeout <= 0 {
+		transfer.Timeout = DefaultTransferTimeout
+	}
+
+	if config.TSIGName != "" {
+		transfer.TSIGName = config.TSIGName
+		transfer.TSIGAlgo = config.TSIGAlgorithm
+		transfer.tsigSecret = map[string]string{config.TSIGName: config.TSIGSecret}
+	}
+
+	return
+}
+
+// srvRecordKey returns the key to identify the SRV record regardless of the TTL.
+func srvRecordKey(srv *dns.SRV) string {
+	return fmt.Sprintf("%s %d %d %d %s", strings.ToLower(srv.Hdr.Name), srv.Priority, srv.Weight, srv.Port, strings.ToLower(srv.Target))
+}
+
+// Refresh updates the SRV records with IXFR, or AXFR if the zone has not been transferred or IXFR failed.
+// The records are kept if the transfer fails.
+// The next refresh is scheduled by the SOA REFRESH timer, or the SOA RETRY timer if the transfer fails (RFC 1034).
+// The zone never transferred has no SOA timers, and is retried after the interval (or the backoff like the domains).
+func (transfer *ZoneTransfer) Refresh() (err error) {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+
+	if transfer.records != nil {
+		msg := &dns.Msg{}
+		msg.SetIxfr(transfer.Zone, transfer.Serial, "", "")
+		err = transfer.transfer(msg)
+
+		if err == nil {
+			transfer.schedule(transfer.refresh)
+			return
+		}
+	}
+
+	msg := &dns.Msg{}
+	msg.SetAxfr(transfer.Zone)
+	err = transfer.transfer(msg)
+
+	if err == nil {
+		transfer.failures = 0
+		transfer.schedule(transfer.refresh)
+		return
+	}
+
+	transfer.status.Error = err.Error()
+
+	if transfer.records != nil {
+		transfer.schedule(transfer.retry)
+		return
+	}
+
+	transfer.failures++
+	wait := transfer.Interval
+
+	if transfer.BackoffMax > 0 {
+		wait = fullJitter(transfer.Interval, transfer.BackoffMax, transfer.failures)
+	}
+
+	transfer.schedule(wait)
+
+	return
+}
+
+// schedule sets the time of the next refresh.
+// The caller must hold the mutex.
+func (transfer *ZoneTransfer) schedule(after time.Duration) {
+	transfer.RefreshAt = time.Now().Add(after)
+	transfer.status.NextTransfer = transfer.RefreshAt
+}
+
+// Due returns whether the refresh timer has expired.
+func (transfer *ZoneTransfer) Due() bool {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	return !time.Now().Before(transfer.RefreshAt)
+}
+
+// Invalidate makes the next refresh due immediately (e.g. the zone is notified).
+func (transfer *ZoneTransfer) Invalidate() {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	transfer.schedule(0)
+}
+
+// NextRefresh returns the time to wait for the next refresh if it is earlier than the wait.
+func (transfer *ZoneTransfer) NextRefresh(wait time.Duration) time.Duration {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	next := transfer.RefreshAt.Sub(time.Now())
+
+	if next < MinRefreshWait {
+		next = MinRefreshWait
+	}
+
+	if next < wait {
+		return next
+	}
+
+	return wait
+}
+
+// transfer receives the zone and applies it to the records.
+// The caller must hold the mutex.
+func (transfer *ZoneTransfer) transfer(msg *dns.Msg) (err error) {
+	rrs, err := transfer.receive(msg)
+
+	if err == nil {
+		err = transfer.apply(rrs)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%s of %s from %s failed: %s", dns.TypeToString[msg.Question[0].Qtype], transfer.Zone, transfer.Primary, err)
+		return
+	}
+
+	transfer.status.Serial = transfer.Serial
+	transfer.status.Records = len(transfer.records)
+	transfer.status.LastTransfer = time.Now()
+	transfer.status.Error = ""
+	return
+}
+
+// receive sends the AXFR or IXFR query and returns the records of all messages until the transfer ends.
+// The transfer ends with the second SOA record of the current serial, or the third one of an incremental transfer (RFC 1995).
+// With the TSIG key, the first and the last messages must be signed and the signatures are verified (RFC 2845 section 4.4).
+// dns.Transfer is not used because it accepts the messages without TSIG.
+func (transfer *ZoneTransfer) receive(msg *dns.Msg) (rrs []dns.RR, err error) {
+	conn, err := dns.DialTimeout("tcp", transfer.Primary, transfer.Timeout)
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+	var out []byte
+	var mac string
+
+	if transfer.TSIGName != "" {
+		msg.SetTsig(transfer.TSIGName, transfer.TSIGAlgo, TSIGFudge, time.Now().Unix())
+		out, mac, err = dns.TsigGenerate(msg, transfer.tsigSecret[transfer.TSIGName], "", false)
+	} else {
+		out, err = msg.Pack()
+	}
+
+	if err != nil {
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(transfer.Timeout))
+
+	if _, err = conn.Write(out); err != nil {
+		return
+	}
+
+	buf := make([]byte, dns.MaxMsgSize)
+	var serial uint32
+	soas := 0
+	incremental := false
+
+	for first := true; ; first = false {
+		conn.SetReadDeadline(time.Now().Add(transfer.Timeout))
+		n, e := conn.Read(buf)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		in := &dns.Msg{}
+
+		if err = in.Unpack(buf[:n]); err != nil {
+			return
+		}
+
+		if in.Id != msg.Id {
+			err = dns.ErrId
+			return
+		}
+
+		if in.Rcode != dns.RcodeSuccess {
+			err = fmt.Errorf("The response code is %s", dns.RcodeToString[in.Rcode])
+			return
+		}
+
+		signed := false
+
+		if transfer.TSIGName != "" {
+			signed, err = transfer.verifyTSIG(buf[:n], in, &mac, !first)
+
+			if err != nil {
+				return
+			}
+
+			if first && !signed {
+				err = fmt.Errorf("The first message is not signed with TSIG")
+				return
+			}
+		}
+
+		if first {
+			soa, ok := firstSOA(in)
+
+			if !ok {
+				err = fmt.Errorf("The transfer does not start with SOA")
+				return
+			}
+
+			serial = soa.Serial
+		}
+
+		rrs = append(rrs, in.Answer...)
+		done := false
+
+		if first && msg.Question[0].Qtype == dns.TypeIXFR && msg.Ns[0].(*dns.SOA).Serial >= serial {
+			// The zone is not changed
+			done = true
+		}
+
+		for _, rr := range in.Answer {
+			if soa, ok := rr.(*dns.SOA); ok {
+				if soa.Serial == serial {
+					soas++
+				} else {
+					incremental = true
+				}
+			}
+		}
+
+		if soas == 3 || soas == 2 && !incremental {
+			done = true
+		}
+
+		if done {
+			if transfer.TSIGName != "" && !signed {
+				err = fmt.Errorf("The last message is not signed with TSIG")
+			}
+
+			return
+		}
+	}
+}
+
+// firstSOA returns the SOA record at the beginning of the answer.
+func firstSOA(msg *dns.Msg) (soa *dns.SOA, ok bool) {
+	if len(msg.Answer) > 0 {
+		soa, ok = msg.Answer[0].(*dns.SOA)
+	}
+
+	return
+}
+
+// verifyTSIG verifies the TSIG of the transfer message chained with the previous MAC, and returns whether it is signed.
+// The messages after the first one are signed with the TSIG timers only (RFC 2845 section 4.4).
+func (transfer *ZoneTransfer) verifyTSIG(raw []byte, msg *dns.Msg, mac *string, timersOnly bool) (signed bool, err error) {
+	tsig := msg.IsTsig()
+
+	if tsig == nil {
+		return
+	}
+
+	if strings.ToLower(tsig.Hdr.Name) != transfer.TSIGName {
+		err = fmt.Errorf("The message is signed with the unknown key %s", tsig.Hdr.Name)
+		return
+	}
+
+	err = dns.TsigVerify(raw, transfer.tsigSecret[transfer.TSIGName], *mac, timersOnly)
+
+	if err != nil {
+		err = fmt.Errorf("TSIG verification failed: %s", err)
+		return
+	}
+
+	*mac = tsig.MAC
+	signed = true
+	return
+}
+
+// apply updates the records with the transferred records (RFC 1995).
+// A full transfer is "SOA records... SOA", an incremental transfer is
+// "SOA (SOA deleted... SOA added...)... SOA" and no change is a single SOA.
+// The caller must hold the mutex.
+func (transfer *ZoneTransfer) apply(rrs []dns.RR) (err error) {
+	if len(rrs) == 0 {
+		err = fmt.Errorf("No record was transferred")
+		return
+	}
+
+	soa, ok := rrs[0].(*dns.SOA)
+
+	if !ok {
+		err = fmt.Errorf("The transfer does not start with SOA")
+		return
+	}
+
+	if len(rrs) == 1 {
+		if transfer.records == nil {
+			err = fmt.Errorf("The zone is not transferred")
+		} else {
+			transfer.setTimers(soa)
+		}
+
+		return
+	}
+
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		err = fmt.Errorf("The transfer does not end with SOA")
+		return
+	}
+
+	rrs = rrs[1 : len(rrs)-1]
+	incremental := false
+
+	if len(rrs) > 0 && transfer.records != nil {
+		_, incremental = rrs[0].(*dns.SOA)
+	}
+
+	records := map[string]*dns.SRV{}
+
+	if incremental {
+		for key, srv := range transfer.records {
+			records[key] = srv
+		}
+	}
+
+	deleting := false
+
+	for _, rr := range rrs {
+		switch r := rr.(type) {
+		case *dns.SOA:
+			// The SOA records switch the deletions and the additions
+			deleting = !deleting
+		case *dns.SRV:
+			if incremental && deleting {
+				delete(records, srvRecordKey(r))
+			} else {
+				records[srvRecordKey(r)] = r
+			}
+		}
+	}
+
+	transfer.records = records
+	transfer.Serial = soa.Serial
+	transfer.setTimers(soa)
+	return
+}
+
+// setTimers sets the refresh and retry timers from the SOA record of the zone.
+// The caller must hold the mutex.
+func (transfer *ZoneTransfer) setTimers(soa *dns.SOA) {
+	transfer.refresh = time.Duration(soa.Refresh) * time.Second
+	transfer.retry = time.Duration(soa.Retry) * time.Second
+}
+
+// matchName returns whether the name (without the trailing dot) matches the include and exclude patterns.
+func (transfer *ZoneTransfer) matchName(name string) bool {
+	for _, pattern := range transfer.Exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+
+	if len(transfer.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range transfer.Include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SRVs returns the SRV records of the zone by name (without the trailing dot).
+// The RRset with the target "." is returned as an empty list (RFC 2782).
+func (transfer *ZoneTransfer) SRVs() (srvsByName map[string][]*dns.SRV) {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	srvsByName = map[string][]*dns.SRV{}
+
+	for _, srv := range transfer.records {
+		name := strings.TrimSuffix(strings.ToLower(srv.Hdr.Name), ".")
+
+		if !transfer.matchName(name) {
+			continue
+		}
+
+		srvsByName[name] = append(srvsByName[name], srv)
+	}
+
+	for name, srvs := range srvsByName {
+		if isUnavailable(srvs) {
+			srvsByName[name] = []*dns.SRV{}
+		} else {
+			sortSRVs(srvs)
+		}
+	}
+
+	return
+}
+
+// Transferred returns whether the zone has been transferred.
+func (transfer *ZoneTransfer) Transferred() bool {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	return transfer.records != nil
+}
+
+// Status returns the result of the last zone transfer.
+func (transfer *ZoneTransfer) Status() TransferStatus {
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	return transfer.status
+}
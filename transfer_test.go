@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func testSOA(serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: "svc.example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:      "ns.example.com.",
+		Mbox:    "hostmaster.example.com.",
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  60,
+	}
+}
+
+func testZoneSRV(name string, target string, port uint16) *dns.SRV {
+	return &dns.SRV{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60}, Priority: 10, Weight: 100, Port: port, Target: target}
+}
+
+// testZone returns the fixture zone of the serial (1 or later).
+func testZone(serial uint32) []dns.RR {
+	rrs := []dns.RR{
+		testZoneSRV("_http._tcp.svc.example.com.", "web1.example.com.", 80),
+		&dns.A{Hdr: dns.RR_Header{Name: "web1.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}},
+	}
+
+	if serial == 1 {
+		rrs = append(rrs, testZoneSRV("_http._tcp.svc.example.com.", "web2.example.com.", 80), testZoneSRV("_mysql._tcp.svc.example.com.", "db1.example.com.", 3306))
+	} else {
+		rrs = append(rrs, testZoneSRV("_http._tcp.svc.example.com.", "web3.example.com.", 80))
+	}
+
+	return rrs
+}
+
+// transferHandler serves the fixture zone of the current serial.
+// IXFR from the serial 1 returns the difference, and IXFR is refused unless ixfr is 1.
+func transferHandler(serial *uint32, ixfr *int32) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		current := atomic.LoadUint32(serial)
+		q := req.Question[0]
+		var envelopes []*dns.Envelope
+
+		switch {
+		case q.Qtype == dns.TypeIXFR && atomic.LoadInt32(ixfr) != 1:
+			m := &dns.Msg{}
+			m.SetRcode(req, dns.RcodeNotImplemented)
+			w.WriteMsg(m)
+			return
+		case q.Qtype == dns.TypeIXFR && req.Ns[0].(*dns.SOA).Serial >= current:
+			envelopes = []*dns.Envelope{{RR: []dns.RR{testSOA(current)}}}
+		case q.Qtype == dns.TypeIXFR && req.Ns[0].(*dns.SOA).Serial == 1:
+			envelopes = []*dns.Envelope{
+				{RR: []dns.RR{testSOA(current), testSOA(1), testZoneSRV("_http._tcp.svc.example.com.", "web2.example.com.", 80)}},
+				{RR: []dns.RR{testZoneSRV("_mysql._tcp.svc.example.com.", "db1.example.com.", 3306), testSOA(current)}},
+				{RR: []dns.RR{testZoneSRV("_http._tcp.svc.example.com.", "web3.example.com.", 80), testSOA(current)}},
+			}
+		default:
+			envelopes = []*dns.Envelope{
+				{RR: append([]dns.RR{testSOA(current)}, testZone(current)...)},
+				{RR: []dns.RR{testSOA(current)}},
+			}
+		}
+
+		ch := make(chan *dns.Envelope)
+
+		go func() {
+			for _, envelope := range envelopes {
+				ch <- envelope
+			}
+
+			close(ch)
+		}()
+
+		(&dns.Transfer{}).Out(w, req, ch)
+	}
+}
+
+func TestZoneTransferRefresh(t *testing.T) {
+	assert := assert.New(t)
+	serial := uint32(1)
+	ixfr := int32(1)
+	addr, shutdown := testutils.StartDNSServer("tcp", transferHandler(&serial, &ixfr), nil)
+
+	transfer := NewZoneTransfer(&Config{Transfer: TransferConfig{Zone: "svc.example.com.", Primary: addr}})
+	assert.False(transfer.Transferred())
+	assert.True(transfer.Due())
+
+	// AXFR
+	assert.Equal(nil, transfer.Refresh())
+	srvs := transfer.SRVs()
+	assert.Equal(2, len(srvs))
+	assert.Equal([]string{"web1.example.com.", "web2.example.com."}, targets(srvs["_http._tcp.svc.example.com"]))
+	assert.Equal([]string{"db1.example.com."}, targets(srvs["_mysql._tcp.svc.example.com"]))
+	assert.Equal(uint32(1), transfer.Status().Serial)
+	assert.Equal(3, transfer.Status().Records)
+
+	// The next refresh is scheduled by the SOA REFRESH timer
+	assert.False(transfer.Due())
+	assert.True(transfer.RefreshAt.After(time.Now().Add(3599 * time.Second)))
+	assert.Equal(transfer.RefreshAt, transfer.Status().NextTransfer)
+	assert.Equal(time.Minute, transfer.NextRefresh(time.Minute))
+	transfer.Invalidate()
+	assert.True(transfer.Due())
+	assert.Equal(MinRefreshWait, transfer.NextRefresh(time.Minute))
+
+	// IXFR without changes
+	assert.Equal(nil, transfer.Refresh())
+	assert.Equal(srvs, transfer.SRVs())
+
+	// IXFR with the difference
+	atomic.StoreUint32(&serial, 2)
+	assert.Equal(nil, transfer.Refresh())
+	srvs = transfer.SRVs()
+	assert.Equal(1, len(srvs))
+	assert.Equal([]string{"web1.example.com.", "web3.example.com."}, targets(srvs["_http._tcp.svc.example.com"]))
+	assert.Equal(uint32(2), transfer.Serial)
+
+	// AXFR if IXFR is refused
+	atomic.StoreUint32(&serial, 3)
+	atomic.StoreInt32(&ixfr, 0)
+	assert.Equal(nil, transfer.Refresh())
+	assert.Equal(srvs, transfer.SRVs())
+	assert.Equal(uint32(3), transfer.Status().Serial)
+
+	// The records are kept if the transfer fails
+	shutdown()
+	err := transfer.Refresh()
+	assert.NotNil(err)
+	assert.Equal(srvs, transfer.SRVs())
+	assert.Equal(err.Error(), transfer.Status().Error)
+	assert.True(transfer.Transferred())
+
+	// The failed transfer is retried by the SOA RETRY timer
+	assert.False(transfer.Due())
+	assert.True(transfer.RefreshAt.Before(time.Now().Add(601 * time.Second)))
+}
+
+func TestZoneTransferSRVsWithPatterns(t *testing.T) {
+	assert := assert.New(t)
+	serial := uint32(1)
+	ixfr := int32(1)
+	addr, shutdown := testutils.StartDNSServer("tcp", transferHandler(&serial, &ixfr), nil)
+	defer shutdown()
+
+	transfer := NewZoneTransfer(&Config{Transfer: TransferConfig{Zone: "svc.example.com.", Primary: addr, Include: []string{"_*._tcp.svc.example.com"}, Exclude: []string{"_mysql.*"}}})
+	assert.Equal(nil, transfer.Refresh())
+	srvs := transfer.SRVs()
+	assert.Equal(1, len(srvs))
+	assert.Equal(2, len(srvs["_http._tcp.svc.example.com"]))
+
+	transfer.Include = []string{"_mysql.*"}
+	transfer.Exclude = nil
+	srvs = transfer.SRVs()
+	assert.Equal(1, len(srvs))
+	assert.Equal(1, len(srvs["_mysql._tcp.svc.example.com"]))
+}
+
+// signedTransferHandler serves AXFR of the fixture zone in two messages, which are signed with TSIG if signed is true.
+func signedTransferHandler(signed ...bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.IsTsig() == nil || w.TsigStatus() != nil {
+			m := &dns.Msg{}
+			w.WriteMsg(m.SetRcode(req, dns.RcodeRefused))
+			return
+		}
+
+		for i, rrs := range [][]dns.RR{append([]dns.RR{testSOA(1)}, testZone(1)...), {testSOA(1)}} {
+			m := &dns.Msg{}
+			m.SetReply(req)
+			m.Answer = rrs
+
+			if signed[i] {
+				m.SetTsig(req.IsTsig().Hdr.Name, req.IsTsig().Algorithm, 300, time.Now().Unix())
+			}
+
+			w.WriteMsg(m)
+			// The messages after the first one are signed with the TSIG timers only
+			w.TsigTimersOnly(true)
+		}
+	}
+}
+
+func TestZoneTransferRefreshWithTSIG(t *testing.T) {
+	assert := assert.New(t)
+
+	for signed, message := range map[[2]bool]string{
+		{true, true}:   "",
+		{false, true}:  "The first message is not signed with TSIG",
+		{true, false}:  "The last message is not signed with TSIG",
+		{false, false}: "The first message is not signed with TSIG",
+	} {
+		server := &dns.Server{Handler: signedTransferHandler(signed[0], signed[1]), TsigSecret: map[string]string{"srvd.": testTSIGSecret}}
+		addr, shutdown := testutils.StartCustomDNSServer("tcp", server, nil)
+
+		transfer := NewZoneTransfer(&Config{
+			Transfer:      TransferConfig{Zone: "svc.example.com.", Primary: addr},
+			TSIGName:      "srvd.",
+			TSIGAlgorithm: dns.HmacSHA256,
+			TSIGSecret:    testTSIGSecret,
+		})
+
+		err := transfer.Refresh()
+
+		if message == "" {
+			assert.Equal(nil, err)
+			assert.Equal(2, len(transfer.SRVs()))
+		} else {
+			assert.Equal("AXFR of svc.example.com. from "+addr+" failed: "+message, err.Error())
+			assert.False(transfer.Transferred())
+		}
+
+		shutdown()
+	}
+}
+
+func TestZoneTransferRefreshFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	// Connection to the closed port is refused
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// The zone never transferred is retried after the interval
+	transfer := NewZoneTransfer(&Config{Transfer: TransferConfig{Zone: "svc.example.com.", Primary: addr}, Interval: 60, DisableBackoff: true})
+	assert.NotNil(transfer.Refresh())
+	assert.False(transfer.Due())
+	assert.InDelta(60, transfer.NextRefresh(time.Hour).Seconds(), 0.5)
+
+	// or backed off
+	transfer = NewZoneTransfer(&Config{Transfer: TransferConfig{Zone: "svc.example.com.", Primary: addr}, Interval: 60, BackoffMax: 300})
+
+	for i := 0; i < 5; i++ {
+		transfer.Refresh()
+	}
+
+	assert.Equal(uint64(5), transfer.failures)
+	assert.True(transfer.RefreshAt.Before(time.Now().Add(301 * time.Second)))
+}
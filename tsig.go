@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultTSIGAlgorithm is the default tsig_algorithm value.
+	DefaultTSIGAlgorithm = dns.HmacSHA256
+	// TSIGFudge is the permitted time difference of TSIG in seconds.
+	TSIGFudge = 300
+)
+
+// TSIGAlgorithms is the supported TSIG algorithms.
+var TSIGAlgorithms = map[string]bool{
+	dns.HmacMD5:    true,
+	dns.HmacSHA1:   true,
+	dns.HmacSHA256: true,
+	dns.HmacSHA512: true,
+}
+
+// LoadTSIGSecret loads the base64 TSIG secret from the file.
+func LoadTSIGSecret(path string) (secret string, err error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	secret = strings.TrimSpace(string(content))
+	return
+}
+
+// signTSIG returns a copy of the message signed with the TSIG key.
+func signTSIG(msg *dns.Msg, name string, algorithm string) *dns.Msg {
+	signed := msg.Copy()
+	signed.SetTsig(name, algorithm, TSIGFudge, time.Now().Unix())
+	return signed
+}
+
+// verifyTSIG checks that the response is signed.
+// The signature itself is verified when reading the response.
+func verifyTSIG(r *dns.Msg, hostPort string) (err error) {
+	tsig := r.IsTsig()
+
+	if tsig == nil {
+		err = fmt.Errorf("The response from %s is not signed with TSIG", hostPort)
+	} else if tsig.Error != dns.RcodeSuccess {
+		err = fmt.Errorf("TSIG was rejected by %s: %s", hostPort, dns.RcodeToString[int(tsig.Error)])
+	}
+
+	return
+}
+
+// isTSIGError returns whether the error occurred in verifying TSIG of the response.
+func isTSIGError(err error) bool {
+	return err == dns.ErrSig || err == dns.ErrTime || err == dns.ErrSecret || err == dns.ErrKeyAlg || err == dns.ErrAlg
+}
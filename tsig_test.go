@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+const testTSIGSecret = "c3J2ZC10ZXN0LXNlY3JldC1rZXktMDEyMzQ1Njc4OQ=="
+
+func tsigHandler(sign bool) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		m := &dns.Msg{}
+		m.SetReply(req)
+
+		if req.IsTsig() == nil || w.TsigStatus() != nil {
+			m.Rcode = dns.RcodeRefused
+			w.WriteMsg(m)
+			return
+		}
+
+		m.Answer = []dns.RR{
+			&dns.SRV{Priority: 10, Weight: 100, Target: "server1.example.com.", Port: 80, Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 3}},
+		}
+
+		if sign {
+			m.SetTsig(req.IsTsig().Hdr.Name, req.IsTsig().Algorithm, 300, time.Now().Unix())
+		}
+
+		w.WriteMsg(m)
+	}
+}
+
+func newTSIGDNSClient(t *testing.T, addr string, secret string) *DNSClient {
+	config := &Config{
+		Domains:       []string{"_mysql._tcp.example.com"},
+		Net:           "tcp",
+		Nameservers:   []string{addr},
+		TSIGName:      "srvd.",
+		TSIGAlgorithm: dns.HmacSHA256,
+		TSIGSecret:    secret,
+	}
+
+	dnsCli, err := NewDNSClient(config)
+	assert.Equal(t, nil, err)
+	return dnsCli
+}
+
+func TestDNSClientDigWithTSIG(t *testing.T) {
+	assert := assert.New(t)
+	server := &dns.Server{Handler: tsigHandler(true), TsigSecret: map[string]string{"srvd.": testTSIGSecret}}
+	addr, shutdown := testutils.StartCustomDNSServer("tcp", server, nil)
+	defer shutdown()
+
+	dnsCli := newTSIGDNSClient(t, addr, testTSIGSecret)
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(1, len(srvs))
+	assert.Equal(DomainStatus{Reason: DomainReasonOK, FQDN: "_mysql._tcp.example.com."}, *dnsCli.Statuses["_mysql._tcp.example.com"])
+}
+
+func TestDNSClientDigWithTSIGUnsigned(t *testing.T) {
+	assert := assert.New(t)
+	server := &dns.Server{Handler: tsigHandler(false), TsigSecret: map[string]string{"srvd.": testTSIGSecret}}
+	addr, shutdown := testutils.StartCustomDNSServer("tcp", server, nil)
+	defer shutdown()
+
+	dnsCli := newTSIGDNSClient(t, addr, testTSIGSecret)
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+
+	assert.Equal(DomainStatus{
+		Reason: DomainReasonError,
+		Error:  "TSIG verification failed: The response from " + addr + " is not signed with TSIG",
+		FQDN:   "_mysql._tcp.example.com.",
+	}, *dnsCli.Statuses["_mysql._tcp.example.com"])
+}
+
+func TestDNSClientDigWithTSIGBadSignature(t *testing.T) {
+	assert := assert.New(t)
+	// The server signs the response with another secret
+	server := &dns.Server{Handler: tsigHandler(true), TsigSecret: map[string]string{"srvd.": "YW5vdGhlci1zZWNyZXQ="}}
+	addr, shutdown := testutils.StartCustomDNSServer("tcp", server, nil)
+	defer shutdown()
+
+	dnsCli := newTSIGDNSClient(t, addr, testTSIGSecret)
+	srvs := dnsCli.Dig()["_mysql._tcp.example.com"]
+	assert.Equal(0, len(srvs))
+	assert.Equal(DomainReasonError, dnsCli.Statuses["_mysql._tcp.example.com"].Reason)
+}
+
+func TestLoadTSIGSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile(testTSIGSecret+"\n", func(f *os.File) {
+		secret, err := LoadTSIGSecret(f.Name())
+		assert.Equal(nil, err)
+		assert.Equal(testTSIGSecret, secret)
+	})
+}
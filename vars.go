@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LoadVarsFile loads the template variables from TOML, JSON or YAML file.
+// The format is determined by the file extension.
+func LoadVarsFile(path string) (vars map[string]interface{}, err error) {
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return
+	}
+
+	vars = map[string]interface{}{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(content, &vars)
+	case ".json":
+		err = json.Unmarshal(content, &vars)
+	case ".yml", ".yaml":
+		var raw map[interface{}]interface{}
+		err = yaml.Unmarshal(content, &raw)
+
+		if err == nil {
+			vars = normalizeVar(raw).(map[string]interface{})
+		}
+	default:
+		err = fmt.Errorf("Unsupported vars_file format: %s", path)
+	}
+
+	return
+}
+
+// normalizeVar converts map[interface{}]interface{} decoded from YAML to map[string]interface{}.
+func normalizeVar(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+
+		for k, e := range val {
+			m[fmt.Sprint(k)] = normalizeVar(e)
+		}
+
+		return m
+	case []interface{}:
+		for i, e := range val {
+			val[i] = normalizeVar(e)
+		}
+	}
+
+	return v
+}
+
+// ExpandVars replaces ${var} or $var in the string values with the environment variables.
+func ExpandVars(v interface{}) interface{} {
+	switch val := v.(type) {
+	case string:
+		return os.ExpandEnv(val)
+	case map[string]interface{}:
+		for k, e := range val {
+			val[k] = ExpandVars(e)
+		}
+	case []interface{}:
+		for i, e := range val {
+			val[i] = ExpandVars(e)
+		}
+	case []map[string]interface{}:
+		for _, e := range val {
+			ExpandVars(e)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withVarsFile(ext string, content string, callback func(path string)) {
+	dir, _ := ioutil.TempDir("", "srvd")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "vars"+ext)
+	ioutil.WriteFile(path, []byte(content), 0644)
+	callback(path)
+}
+
+func TestLoadVarsFileTOML(t *testing.T) {
+	assert := assert.New(t)
+
+	content := `
+port = 3306
+[backend]
+maxconn = 100
+`
+
+	withVarsFile(".toml", content, func(path string) {
+		vars, err := LoadVarsFile(path)
+		assert.Equal(nil, err)
+		assert.Equal(int64(3306), vars["port"])
+		assert.Equal(map[string]interface{}{"maxconn": int64(100)}, vars["backend"])
+	})
+}
+
+func TestLoadVarsFileJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	withVarsFile(".json", `{"port": 3306, "backend": {"maxconn": 100}}`, func(path string) {
+		vars, err := LoadVarsFile(path)
+		assert.Equal(nil, err)
+		assert.Equal(float64(3306), vars["port"])
+		assert.Equal(map[string]interface{}{"maxconn": float64(100)}, vars["backend"])
+	})
+}
+
+func TestLoadVarsFileYAML(t *testing.T) {
+	assert := assert.New(t)
+
+	content := `
+port: 3306
+backend:
+  maxconn: 100
+  hosts: [{name: db1}]
+`
+
+	withVarsFile(".yml", content, func(path string) {
+		vars, err := LoadVarsFile(path)
+		assert.Equal(nil, err)
+		assert.Equal(3306, vars["port"])
+
+		assert.Equal(map[string]interface{}{
+			"maxconn": 100,
+			"hosts":   []interface{}{map[string]interface{}{"name": "db1"}},
+		}, vars["backend"])
+	})
+}
+
+func TestLoadVarsFileUnsupported(t *testing.T) {
+	assert := assert.New(t)
+
+	withVarsFile(".ini", "port=3306", func(path string) {
+		_, err := LoadVarsFile(path)
+		assert.Equal("Unsupported vars_file format: "+path, err.Error())
+	})
+}
+
+func TestExpandVars(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("SRVD_TEST_PORT", "3306")
+	defer os.Unsetenv("SRVD_TEST_PORT")
+
+	vars := map[string]interface{}{
+		"bind":    "*:${SRVD_TEST_PORT}",
+		"maxconn": int64(100),
+		"ports":   []interface{}{"$SRVD_TEST_PORT"},
+		"backend": map[string]interface{}{"port": "${SRVD_TEST_PORT}"},
+	}
+
+	assert.Equal(map[string]interface{}{
+		"bind":    "*:3306",
+		"maxconn": int64(100),
+		"ports":   []interface{}{"3306"},
+		"backend": map[string]interface{}{"port": "3306"},
+	}, ExpandVars(vars))
+}
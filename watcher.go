@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// WatchDebounce is the time to wait for the template files to settle after a change.
+	WatchDebounce = 100 * time.Millisecond
+)
+
+// Watcher struct has information on the inotify watcher of the template files.
+type Watcher struct {
+	Files     map[string]bool
+	Events    chan string
+	fsWatcher *fsnotify.Watcher
+	mutex     sync.Mutex
+}
+
+// NewWatcher creates Watcher struct.
+func NewWatcher(files []string) (watcher *Watcher, err error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return
+	}
+
+	watcher = &Watcher{
+		Files:     map[string]bool{},
+		Events:    make(chan string, 1),
+		fsWatcher: fsWatcher,
+	}
+
+	err = watcher.Watch(files)
+
+	if err != nil {
+		fsWatcher.Close()
+		return
+	}
+
+	go watcher.run()
+	return
+}
+
+// Watch adds the files to be watched.
+// It watches the directories containing the files so that files replaced by rename are also detected.
+func (watcher *Watcher) Watch(files []string) (err error) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	for _, file := range files {
+		path, e := filepath.Abs(file)
+
+		if e != nil {
+			err = e
+			return
+		}
+
+		if watcher.Files[path] {
+			continue
+		}
+
+		err = watcher.fsWatcher.Add(filepath.Dir(path))
+
+		if err != nil {
+			return
+		}
+
+		watcher.Files[path] = true
+	}
+
+	return
+}
+
+func (watcher *Watcher) isWatched(path string) bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	return watcher.Files[path]
+}
+
+func (watcher *Watcher) run() {
+	defer close(watcher.Events)
+	var changed string
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case event, ok := <-watcher.fsWatcher.Events:
+			if !ok {
+				return
+			}
+
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			path, _ := filepath.Abs(event.Name)
+
+			if !watcher.isWatched(path) {
+				continue
+			}
+
+			// Coalesce bursts of events (e.g. truncate and write) into one notification
+			changed = path
+			debounce = time.After(WatchDebounce)
+		case <-debounce:
+			debounce = nil
+
+			select {
+			case watcher.Events <- changed:
+			default:
+			}
+		case err, ok := <-watcher.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Println("WARNING: Template watching failed:", err)
+		}
+	}
+}
+
+// Close stops watching the files.
+func (watcher *Watcher) Close() error {
+	return watcher.fsWatcher.Close()
+}
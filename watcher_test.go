@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/winebarrel/srvd/testutils"
+)
+
+func TestWatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("server.example.com.", func(f *os.File) {
+		watcher, err := NewWatcher([]string{f.Name()})
+		assert.Equal(nil, err)
+		defer watcher.Close()
+
+		ioutil.WriteFile(f.Name(), []byte("server2.example.com."), 0644)
+
+		select {
+		case path := <-watcher.Events:
+			expected, _ := filepath.Abs(f.Name())
+			assert.Equal(expected, path)
+		case <-time.After(3 * time.Second):
+			assert.Fail("template change was not detected")
+		}
+	})
+}
+
+func TestWatcherIgnoreOtherFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	testutils.TempFile("server.example.com.", func(f *os.File) {
+		testutils.TempFile("server.example.com.", func(other *os.File) {
+			watcher, err := NewWatcher([]string{f.Name()})
+			assert.Equal(nil, err)
+			defer watcher.Close()
+
+			ioutil.WriteFile(other.Name(), []byte("server2.example.com."), 0644)
+
+			select {
+			case path := <-watcher.Events:
+				assert.Fail("unexpected event: " + path)
+			case <-time.After(500 * time.Millisecond):
+			}
+		})
+	})
+}
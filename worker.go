@@ -5,6 +5,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/okzk/sdnotify"
 )
 
@@ -40,6 +41,8 @@ func (worker *Worker) Run() {
 		return
 	}
 
+	// The prefetches in progress are finished before the worker is done
+	defer dnsCli.Wait()
 	status := Status{}
 	tmpl, err := NewTemplate(worker.Config, &status)
 
@@ -49,31 +52,149 @@ func (worker *Worker) Run() {
 		return
 	}
 
+	var watcher *Watcher
+	var watchChan chan string
+
+	if worker.Config.Watch && !worker.Config.Oneshot {
+		watcher, err = NewWatcher(tmpl.WatchFiles())
+
+		if err != nil {
+			worker.DoneChan <- fmt.Errorf("Watcher struct creation failed: %s", err)
+			close(worker.StopChan)
+			return
+		}
+
+		defer watcher.Close()
+		watchChan = watcher.Events
+	}
+
+	var transfer *ZoneTransfer
+
+	if worker.Config.Transfer.Zone != "" {
+		transfer = NewZoneTransfer(worker.Config)
+	}
+
+	var notifyListener *NotifyListener
+	var notifyChan chan bool
+
+	if worker.Config.Notify.Listen != "" && !worker.Config.Oneshot {
+		notifyListener, err = NewNotifyListener(&worker.Config.Notify)
+
+		if err != nil {
+			worker.DoneChan <- fmt.Errorf("NOTIFY listener creation failed: %s", err)
+			close(worker.StopChan)
+			return
+		}
+
+		defer notifyListener.Close()
+		notifyListener.Run()
+		notifyChan = notifyListener.WakeChan
+	}
+
 	interval := time.Duration(worker.Config.Interval) * time.Second
 	cooldown := time.Duration(worker.Config.Cooldown) * time.Second
 	updatedAt := time.Now().Add(-cooldown)
+	reason := ChangeReasonDNS
+	var srvsByDomain map[string][]*dns.SRV
+	dnsErr := false
+
+	// Randomize the polling phase so that the hosts do not query the resolvers at the same time
+	if splay := Splay(time.Duration(worker.Config.Splay) * time.Second); splay > 0 {
+		log.Printf("Waiting %s before the first lookup (splay)", splay.Round(time.Millisecond))
+
+		select {
+		case <-worker.StopChan:
+			return
+		case <-time.After(splay):
+		}
+	}
 
 	for {
-		srvsByDomain := dnsCli.Dig()
-		dnsErr := false
 		now := time.Now()
 
-		for domain, srvs := range srvsByDomain {
-			if len(srvs) == 0 {
-				log.Printf("ERROR: %s SRV record not found", domain)
-				dnsErr = true
+		if reason == ChangeReasonDNS || reason == ChangeReasonNotify {
+			srvsByDomain = dnsCli.Dig()
+			status.Domains = dnsCli.DomainStatuses()
+			metrics := dnsCli.CurrentMetrics()
+			status.Metrics = &metrics
+			status.Servers = dnsCli.ServerHealths()
+			dnsErr = false
+
+			for domain, srvs := range srvsByDomain {
+				// An empty answer of the decidedly unavailable service is rendered as it is
+				if len(srvs) == 0 && !dnsCli.IsUnavailable(domain) {
+					if domainStatus, ok := status.Domains[domain]; ok && domainStatus.Reason == DomainReasonError {
+						log.Printf("ERROR: %s SRV lookup failed: %s", domain, domainStatus.Error)
+					} else {
+						log.Printf("ERROR: %s SRV record not found", domain)
+					}
+
+					dnsErr = true
+				}
+			}
+
+			if transfer != nil {
+				if transfer.Due() {
+					err = transfer.Refresh()
+
+					if err != nil {
+						log.Printf("ERROR: Zone transfer failed: %s", err)
+					}
+				}
+
+				// The SRV records transferred last time are used until the transfer recovers
+				if !transfer.Transferred() {
+					dnsErr = true
+				}
+
+				tmpl.Zone = transfer.SRVs()
+				transferStatus := transfer.Status()
+				status.Transfer = &transferStatus
+			}
+
+			if len(dnsCli.Services) > 0 {
+				tmpl.Browse = dnsCli.Browse()
+				status.Browse = dnsCli.ServiceStatuses()
+
+				for service, instances := range tmpl.Browse {
+					if len(instances) == 0 {
+						if browseStatus := status.Browse[service]; browseStatus.Reason == DomainReasonError {
+							log.Printf("ERROR: %s browsing failed: %s", service, browseStatus.Error)
+						} else {
+							log.Printf("ERROR: %s service instance not found", service)
+						}
+
+						dnsErr = true
+					}
+				}
 			}
 		}
 
+		cooling := false
+
 		if dnsErr {
 			status.Ok = false
-		} else if updatedAt.Add(cooldown).Before(now) {
+		} else if reason == ChangeReasonTemplate || updatedAt.Add(cooldown).Before(now) {
+			// A template change is rendered with the cached SRV records regardless of the cooldown
 			updated := tmpl.Process(srvsByDomain)
 
+			if watcher != nil {
+				// Watch the partials included by the template
+				err = watcher.Watch(tmpl.WatchFiles())
+
+				if err != nil {
+					log.Println("WARNING: Template watching failed:", err)
+				}
+			}
+
 			if updated {
+				log.Printf("The configuration file has been updated by the %s change", reason)
 				updatedAt = now
 				status.LastUpdate = updatedAt
+				status.LastChangeReason = reason
 			}
+		} else {
+			cooling = true
 		}
 
 		worker.StatusChan <- status
@@ -89,11 +210,42 @@ func (worker *Worker) Run() {
 			return
 		}
 
+		wait := dnsCli.NextRefresh(interval)
+
+		// With ttl_refresh, the worker also wakes when the refresh timer of the zone expires
+		if transfer != nil && dnsCli.TTLRefresh {
+			wait = transfer.NextRefresh(wait)
+		}
+
+		// The change held back by the cooldown is rendered when the cooldown ends
+		if cooldownEnd := updatedAt.Add(cooldown).Sub(now); cooling && cooldownEnd < wait {
+			wait = cooldownEnd
+		}
+
 		select {
 		case <-worker.StopChan:
 			return
-		case <-time.After(interval):
-			continue
+		case path, ok := <-watchChan:
+			if !ok {
+				watchChan = nil
+				continue
+			}
+
+			log.Printf("The template %s has been changed", path)
+			reason = ChangeReasonTemplate
+		case <-notifyChan:
+			for _, zone := range notifyListener.PendingZones() {
+				domains := dnsCli.Invalidate(zone)
+				log.Printf("NOTIFY of %s invalidated the cache of %d domain(s)", zone, len(domains))
+
+				if transfer != nil && zone == transfer.Zone {
+					transfer.Invalidate()
+				}
+			}
+
+			reason = ChangeReasonNotify
+		case <-time.After(wait):
+			reason = ChangeReasonDNS
 		}
 	}
 }
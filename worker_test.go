@@ -1,7 +1,12 @@
 package main
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bouk/monkey"
 	"github.com/miekg/dns"
@@ -179,3 +184,299 @@ func TestWorkerRecordNotFound(t *testing.T) {
 	worker.Run()
 	assert.Equal(false, status.Ok)
 }
+
+func TestWorkerTemplateChanged(t *testing.T) {
+	assert := assert.New(t)
+	workerStopChan := make(chan bool)
+	workerDoneChan := make(chan error)
+	statusChan := make(chan Status)
+
+	worker := &Worker{
+		Config:     &Config{Interval: 60, Watch: true},
+		StopChan:   workerStopChan,
+		DoneChan:   workerDoneChan,
+		StatusChan: statusChan,
+	}
+
+	digCount := 0
+	var digGuard, processGuard **monkey.PatchGuard
+
+	defer func() {
+		(*digGuard).Unpatch()
+		(*processGuard).Unpatch()
+	}()
+
+	monkey.Patch(NewDNSClient, func(config *Config) (dnsCli *DNSClient, err error) {
+		defer monkey.Unpatch(NewDNSClient)
+		dnsCli = &DNSClient{}
+
+		testutils.PatchMethod(dnsCli, "Dig", func(guard **monkey.PatchGuard) interface{} {
+			digGuard = guard
+
+			return func(_ *DNSClient) (srvsByDomain map[string][]*dns.SRV) {
+				digCount++
+
+				srvsByDomain = map[string][]*dns.SRV{
+					"_mysql._tcp.example.com": []*dns.SRV{&dns.SRV{Target: "server.example.com."}},
+				}
+
+				return
+			}
+		})
+
+		return
+	})
+
+	testutils.TempFile("server.example.com.", func(f *os.File) {
+		monkey.Patch(NewTemplate, func(config *Config, status *Status) (tmpl *Template, err error) {
+			defer monkey.Unpatch(NewTemplate)
+			tmpl = &Template{Src: f.Name(), Status: status}
+
+			testutils.PatchMethod(tmpl, "Process", func(guard **monkey.PatchGuard) interface{} {
+				processGuard = guard
+
+				return func(tp *Template, _ map[string][]*dns.SRV) (updated bool) {
+					tp.Status.Ok = true
+					updated = true
+					return
+				}
+			})
+
+			return
+		})
+
+		var statuses []Status
+
+		go func() {
+			statuses = append(statuses, <-statusChan)
+			ioutil.WriteFile(f.Name(), []byte("server2.example.com."), 0644)
+			statuses = append(statuses, <-statusChan)
+			close(workerStopChan)
+		}()
+
+		worker.Run()
+		assert.Equal(2, len(statuses))
+		assert.Equal(ChangeReasonDNS, statuses[0].LastChangeReason)
+		assert.Equal(ChangeReasonTemplate, statuses[1].LastChangeReason)
+		assert.Equal(1, digCount)
+	})
+}
+
+func TestWorkerUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	workerStopChan := make(chan bool)
+	workerDoneChan := make(chan error)
+	statusChan := make(chan Status)
+
+	worker := &Worker{
+		Config:     &Config{Interval: 60},
+		StopChan:   workerStopChan,
+		DoneChan:   workerDoneChan,
+		StatusChan: statusChan,
+	}
+
+	monkey.Patch(NewDNSClient, func(config *Config) (dnsCli *DNSClient, err error) {
+		defer monkey.Unpatch(NewDNSClient)
+		dnsCli = &DNSClient{Statuses: map[string]*DomainStatus{}}
+
+		testutils.PatchMethod(dnsCli, "Dig", func(guard **monkey.PatchGuard) interface{} {
+			return func(dc *DNSClient) (srvsByDomain map[string][]*dns.SRV) {
+				defer (*guard).Unpatch()
+				(*guard).Restore()
+
+				srvsByDomain = map[string][]*dns.SRV{
+					"_mysql._tcp.example.com": []*dns.SRV{},
+				}
+
+				dc.Statuses["_mysql._tcp.example.com"] = &DomainStatus{Reason: DomainReasonUnavailable}
+				return
+			}
+		})
+
+		return
+	})
+
+	monkey.Patch(NewTemplate, func(config *Config, status *Status) (tmpl *Template, err error) {
+		defer monkey.Unpatch(NewTemplate)
+		tmpl = &Template{Status: status}
+
+		testutils.PatchMethod(tmpl, "Process", func(guard **monkey.PatchGuard) interface{} {
+			return func(tp *Template, _ map[string][]*dns.SRV) (updated bool) {
+				defer (*guard).Unpatch()
+				(*guard).Restore()
+				tp.Status.Ok = true
+				updated = true
+				return
+			}
+		})
+
+		return
+	})
+
+	var status Status
+
+	go func() {
+		status = <-statusChan
+		close(workerStopChan)
+	}()
+
+	worker.Run()
+	assert.Equal(true, status.Ok)
+	assert.Equal(map[string]DomainStatus{"_mysql._tcp.example.com": DomainStatus{Reason: DomainReasonUnavailable}}, status.Domains)
+}
+
+func TestWorkerNotified(t *testing.T) {
+	assert := assert.New(t)
+	workerStopChan := make(chan bool)
+	workerDoneChan := make(chan error)
+	statusChan := make(chan Status)
+	var queries int32
+
+	addr, shutdown := testutils.StartDNSServer("tcp", dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		srvHandler(3600)(w, req)
+	}), nil)
+
+	defer shutdown()
+
+	// Reserve the port of the NOTIFY listener
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	notifyAddr := pc.LocalAddr().String()
+	pc.Close()
+
+	worker := &Worker{
+		Config: &Config{
+			Domains:     []string{"_mysql._tcp.example.com"},
+			Net:         "tcp",
+			Nameservers: []string{addr},
+			Interval:    3600,
+			Notify:      NotifyConfig{Listen: notifyAddr, Zones: []string{"example.com."}},
+		},
+		StopChan:   workerStopChan,
+		DoneChan:   workerDoneChan,
+		StatusChan: statusChan,
+	}
+
+	var patchGuard **monkey.PatchGuard
+	defer func() { (*patchGuard).Unpatch() }()
+
+	monkey.Patch(NewTemplate, func(config *Config, status *Status) (tmpl *Template, err error) {
+		defer monkey.Unpatch(NewTemplate)
+		tmpl = &Template{Status: status}
+
+		testutils.PatchMethod(tmpl, "Process", func(guard **monkey.PatchGuard) interface{} {
+			patchGuard = guard
+
+			return func(tp *Template, _ map[string][]*dns.SRV) (updated bool) {
+				tp.Status.Ok = true
+				updated = true
+				return
+			}
+		})
+
+		return
+	})
+
+	var statuses []Status
+
+	go func() {
+		statuses = append(statuses, <-statusChan)
+		m := &dns.Msg{}
+		m.SetNotify("example.com.")
+		(&dns.Client{}).Exchange(m, notifyAddr)
+
+		// The worker wakes long before the interval
+		select {
+		case status := <-statusChan:
+			statuses = append(statuses, status)
+		case <-time.After(5 * time.Second):
+		}
+
+		close(workerStopChan)
+	}()
+
+	worker.Run()
+	assert.Equal(2, len(statuses))
+	assert.Equal(ChangeReasonNotify, statuses[1].LastChangeReason)
+	// The cached SRV records are invalidated by NOTIFY
+	assert.Equal(int32(2), atomic.LoadInt32(&queries))
+}
+
+func TestWorkerCooldownWithTTLRefresh(t *testing.T) {
+	assert := assert.New(t)
+	workerStopChan := make(chan bool)
+	workerDoneChan := make(chan error)
+	statusChan := make(chan Status)
+
+	addr, shutdown := testutils.StartDNSServer("tcp", srvHandler(3600), nil)
+	defer shutdown()
+
+	// Reserve the port of the NOTIFY listener
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	notifyAddr := pc.LocalAddr().String()
+	pc.Close()
+
+	worker := &Worker{
+		Config: &Config{
+			Domains:     []string{"_mysql._tcp.example.com"},
+			Net:         "tcp",
+			Nameservers: []string{addr},
+			Interval:    3600,
+			Cooldown:    1,
+			TTLRefresh:  true,
+			Notify:      NotifyConfig{Listen: notifyAddr, Zones: []string{"example.com."}},
+		},
+		StopChan:   workerStopChan,
+		DoneChan:   workerDoneChan,
+		StatusChan: statusChan,
+	}
+
+	var patchGuard **monkey.PatchGuard
+	defer func() { (*patchGuard).Unpatch() }()
+	var processCount int32
+
+	monkey.Patch(NewTemplate, func(config *Config, status *Status) (tmpl *Template, err error) {
+		defer monkey.Unpatch(NewTemplate)
+		tmpl = &Template{Status: status}
+
+		testutils.PatchMethod(tmpl, "Process", func(guard **monkey.PatchGuard) interface{} {
+			patchGuard = guard
+
+			return func(tp *Template, _ map[string][]*dns.SRV) (updated bool) {
+				atomic.AddInt32(&processCount, 1)
+				tp.Status.Ok = true
+				updated = true
+				return
+			}
+		})
+
+		return
+	})
+
+	var statuses []Status
+
+	go func() {
+		statuses = append(statuses, <-statusChan)
+		m := &dns.Msg{}
+		m.SetNotify("example.com.")
+		(&dns.Client{}).Exchange(m, notifyAddr)
+
+		// The change within the cooldown is rendered when the cooldown ends, not when the TTL expires
+		for i := 0; i < 2; i++ {
+			select {
+			case status := <-statusChan:
+				statuses = append(statuses, status)
+			case <-time.After(5 * time.Second):
+			}
+		}
+
+		close(workerStopChan)
+	}()
+
+	worker.Run()
+	assert.Equal(3, len(statuses))
+	// NOTIFY within the cooldown is held back
+	assert.Equal(statuses[0].LastUpdate, statuses[1].LastUpdate)
+	assert.True(statuses[2].LastUpdate.After(statuses[0].LastUpdate))
+	assert.Equal(int32(2), atomic.LoadInt32(&processCount))
+}